@@ -0,0 +1,135 @@
+package analyzer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"tdat-backend/internal/parser"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Thread activities, what a thread is doing judged from its frames rather than its Java state
+const (
+	ActivityIdle           = "idle"            // Worker waiting for its next task
+	ActivityNetworkIO      = "io-network"      // Socket read, write, accept or selector
+	ActivityDatabaseIO     = "io-db"           // Inside a JDBC driver or waiting for a connection
+	ActivityFileIO         = "io-file"         // File reads and writes
+	ActivityLockContention = "lock-contention" // Waiting for a monitor or a j.u.c. lock
+	ActivitySleeping       = "sleeping"        // Thread.sleep, time.Sleep
+	ActivityCPU            = "cpu"             // RUNNABLE in no known wait
+	ActivityWaiting        = "waiting"         // Waiting in no known signature, e.g. Object.wait or Future.get
+)
+
+/* YAML Configuration Structures */
+
+// activitySignature assigns an activity to threads with one of the frames, in one of the states
+type activitySignature struct {
+	Activity string   `yaml:"activity"`
+	Frames   []string `yaml:"frames"`    // Prefixes of "package.Class.method", any frame of the stack matches
+	ParkedOn []string `yaml:"parked_on"` // Prefixes of the class in "- parking to wait for <...> (a Class)"
+	States   []string `yaml:"states"`    // Optional, a signature without frames matches on the state alone
+}
+
+type activitiesConfig struct {
+	Signatures []activitySignature `yaml:"signatures"`
+}
+
+/* Activity Classification */
+
+// ActivityClassifier gives each thread an activity from the first matching signature
+type ActivityClassifier struct {
+	signatures []activitySignature
+}
+
+// NewActivityClassifier loads the signatures, their order in the file is the order they are tried in
+func NewActivityClassifier(configPath string) (*ActivityClassifier, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file '%s': %w", configPath, err)
+	}
+
+	var config activitiesConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	for i, sig := range config.Signatures {
+		if sig.Activity == "" {
+			return nil, fmt.Errorf("signature %d has no activity", i+1)
+		}
+		if len(sig.Frames) == 0 && len(sig.ParkedOn) == 0 && len(sig.States) == 0 {
+			return nil, fmt.Errorf("signature %d (%s) needs frames, parked_on or states", i+1, sig.Activity)
+		}
+	}
+	return &ActivityClassifier{signatures: config.Signatures}, nil
+}
+
+// Classify sets the activity of each thread in-place, it runs after Enrich so pool idle frames are known.
+// JVM internal threads and threads without a stack keep an empty activity.
+func (ac *ActivityClassifier) Classify(threads []parser.Thread) {
+	for i := range threads {
+		t := &threads[i]
+		switch {
+		case t.IsPoolIdle:
+			t.Activity = ActivityIdle
+		case t.Kind == parser.ThreadKindJVMInternal:
+			t.Activity = ""
+		default:
+			t.Activity = ac.classify(t)
+		}
+	}
+}
+
+func (ac *ActivityClassifier) classify(t *parser.Thread) string {
+	names := make([]string, len(t.Frames))
+	var parkedOn []string
+	for i, f := range t.Frames {
+		names[i] = f.QualifiedName()
+		for _, lock := range f.Locks {
+			if lock.Action == "parking to wait for" {
+				parkedOn = append(parkedOn, lock.Class)
+			}
+		}
+	}
+	for _, sig := range ac.signatures {
+		if len(sig.States) > 0 && !containsString(sig.States, t.State) {
+			continue
+		}
+		if len(sig.Frames) == 0 && len(sig.ParkedOn) == 0 {
+			return sig.Activity
+		}
+		if anyHasPrefix(names, sig.Frames) || anyHasPrefix(parkedOn, sig.ParkedOn) {
+			return sig.Activity
+		}
+	}
+
+	// Fallback on the state
+	if len(t.Frames) == 0 {
+		return ""
+	}
+	if t.State == "RUNNABLE" {
+		return ActivityCPU
+	}
+	return ActivityWaiting
+}
+
+// anyHasPrefix reports whether one of the frame names or lock classes starts with one of the prefixes
+func anyHasPrefix(names, prefixes []string) bool {
+	for _, name := range names {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,17 +1,46 @@
 package analyzer
 
 import (
+	"sort"
 	"tdat-backend/internal/parser"
+	"time"
 )
 
 // A thread's state at a single point in time from one dump file
 type ThreadSnapshot struct {
-	FileName      string   `json:"dump_name"`
-	State         string   `json:"state"`
-	StackTrace    []string `json:"stack_trace"`
-	ElapsedTime   float64  `json:"elapsed_time_s"`
-	CPUTime       float64  `json:"cpu_time_ms"`
-	CPUPercentage float64  `json:"cpu_percent"`
+	FileName   string    `json:"dump_name"`
+	CapturedAt time.Time `json:"captured_at,omitzero"`
+	State      string    `json:"state"`
+	// The stack is shared through the stack table of the response, StackID references it
+	StackID    string              `json:"stack_id,omitempty"`
+	StackTrace []string            `json:"-"`
+	Frames     []parser.StackFrame `json:"-"`
+	// j.u.c. locks owned by the thread
+	OwnedSynchronizers []parser.LockAnnotation `json:"owned_synchronizers,omitempty"`
+	ElapsedTime        float64                 `json:"elapsed_time_s"`
+	CPUTime            float64                 `json:"cpu_time_ms"`
+	CPUPercentage      float64                 `json:"cpu_percent"`
+	IsDeadlocked       bool                    `json:"is_deadlocked,omitempty"`
+	IsPoolIdle         bool                    `json:"is_pool_idle,omitempty"`
+	Activity           string                  `json:"activity,omitempty"`
+	// CPU used since the previous snapshot, absent on the first one
+	CPUDeltaPercentage float64 `json:"cpu_delta_percent"`
+	HasCPUDelta        bool    `json:"has_cpu_delta,omitempty"`
+	// Header attributes
+	SequenceNumber int    `json:"seq,omitempty"`
+	IsDaemon       bool   `json:"is_daemon"`
+	Priority       int    `json:"priority,omitempty"`
+	OSPriority     int    `json:"os_priority"`
+	StackAddress   string `json:"stack_address,omitempty"`
+	// Virtual thread relationships at the time of this snapshot
+	IsCarrier        bool   `json:"is_carrier,omitempty"`
+	CarrierID        string `json:"carrier_id,omitempty"`
+	MountedVirtualID string `json:"mounted_virtual_id,omitempty"`
+	IsPinned         bool   `json:"is_pinned,omitempty"`
+	// Goroutine status
+	WaitReason     string  `json:"wait_reason,omitempty"`
+	WaitDuration   float64 `json:"wait_duration_s,omitempty"`
+	LockedToThread bool    `json:"locked_to_thread,omitempty"`
 	// Include findings from the rules engine for this specific snapshot
 	RiskLevel      string   `json:"risk_level,omitempty"`
 	Issues         []string `json:"issues,omitempty"`
@@ -25,8 +54,17 @@ type AnalyzedThread struct {
 	Name       string `json:"name"`
 	NativeID   int64  `json:"native_id"`
 	ThreadPool string `json:"thread_pool"`
+	Container  string `json:"container,omitempty"`
+	IsVirtual  bool   `json:"is_virtual,omitempty"`
+	Kind       string `json:"kind"`
+	// Category of JVM internal threads: "gc", "compiler", "vm", "other"
+	InternalCategory string `json:"internal_category,omitempty"`
+	// Function that started a goroutine
+	CreatedBy string `json:"created_by,omitempty"`
 	// A chronological sequence of this thread's state
 	Snapshots []ThreadSnapshot `json:"snapshots"`
+	// Results of the analyses across snapshots
+	Findings []Finding `json:"findings,omitempty"`
 }
 
 // threadKey is a private struct used as a map key for matching threads.
@@ -35,12 +73,49 @@ type threadKey struct {
 	Name       string
 	NativeID   int64
 	ThreadPool string
+	IsVirtual  bool
+}
+
+// keyFor builds the identity of a thread across dumps.
+func keyFor(t parser.Thread) threadKey {
+	// Virtual threads are unnamed, have no native thread and move between carriers, only the tid identifies them
+	if t.IsVirtual {
+		return threadKey{ID: t.ID, IsVirtual: true}
+	}
+	return threadKey{
+		ID:         t.ID,
+		Name:       t.Name,
+		NativeID:   t.NativeID,
+		ThreadPool: t.ThreadPool,
+	}
 }
 
 // ParsedFile is a temporary container holding the results of parsing one file.
 type ParsedFile struct {
 	FileName string
-	Threads  []parser.Thread
+	*parser.ThreadDump
+}
+
+// DumpReport carries the dump-level results of one parsed file.
+type DumpReport struct {
+	FileName  string              `json:"dump_name"`
+	Format    string              `json:"format"`
+	Metadata  parser.DumpMetadata `json:"metadata"`
+	Deadlocks []parser.Deadlock   `json:"deadlocks,omitempty"`
+	Locks     *parser.LockGraph   `json:"locks,omitempty"`
+	// CPU spent by the JVM itself, kept apart from application threads
+	InternalCPU []InternalCPUSummary `json:"internal_cpu,omitempty"`
+	Pools       []PoolStats          `json:"pools,omitempty"`
+	// Groups of threads with the same top frames
+	Clusters []StackCluster `json:"clusters,omitempty"`
+}
+
+// InternalCPUSummary totals the CPU of the JVM internal threads of one category in a dump
+type InternalCPUSummary struct {
+	Category      string  `json:"category"` // "gc", "compiler", "vm", "other"
+	ThreadCount   int     `json:"thread_count"`
+	CPUTime       float64 `json:"cpu_time_ms"`
+	CPUPercentage float64 `json:"cpu_percent"`
 }
 
 // AggregateThreads takes parsed data from multiple files and groups it by thread identity.
@@ -54,12 +129,7 @@ func AggregateThreads(parsedFiles []ParsedFile) []AnalyzedThread {
 	for _, file := range parsedFiles {
 		for _, t := range file.Threads {
 			// Define what makes this thread unique across files
-			key := threadKey{
-				ID:         t.ID,
-				Name:       t.Name,
-				NativeID:   t.NativeID,
-				ThreadPool: t.ThreadPool,
-			}
+			key := keyFor(t)
 
 			// Check if thread already exists from a previous dump file
 			if _, exists := threadMap[key]; !exists {
@@ -69,7 +139,13 @@ func AggregateThreads(parsedFiles []ParsedFile) []AnalyzedThread {
 					Name:       t.Name,
 					NativeID:   t.NativeID,
 					ThreadPool: t.ThreadPool,
-					Snapshots:  []ThreadSnapshot{},
+					Container:  t.Container,
+					IsVirtual:  t.IsVirtual,
+					Kind:       t.Kind,
+
+					InternalCategory: t.InternalCategory,
+					CreatedBy:        t.CreatedBy,
+					Snapshots:        []ThreadSnapshot{},
 				}
 
 				// Add to map for future lookups
@@ -81,15 +157,35 @@ func AggregateThreads(parsedFiles []ParsedFile) []AnalyzedThread {
 
 			// Create snapshot (data specific to just this one file)
 			snapshot := ThreadSnapshot{
-				FileName:       file.FileName,
-				State:          t.State,
-				StackTrace:     t.StackTrace,
-				ElapsedTime:    t.ElapsedTime,
-				CPUTime:        t.CPUTime,
-				CPUPercentage:  t.CPUPercentage,
-				RiskLevel:      t.RiskLevel,
-				Issues:         t.Issues,
-				Recommendation: t.Recommendation,
+				FileName:           file.FileName,
+				CapturedAt:         file.Metadata.CaptureTime,
+				State:              t.State,
+				StackTrace:         t.StackTrace,
+				Frames:             t.Frames,
+				OwnedSynchronizers: t.OwnedSynchronizers,
+				ElapsedTime:        t.ElapsedTime,
+				CPUTime:            t.CPUTime,
+				CPUPercentage:      t.CPUPercentage,
+				IsDeadlocked:       t.IsDeadlocked,
+				IsPoolIdle:         t.IsPoolIdle,
+				Activity:           t.Activity,
+				CPUDeltaPercentage: t.CPUDeltaPercentage,
+				HasCPUDelta:        t.HasCPUDelta,
+				SequenceNumber:     t.SequenceNumber,
+				IsDaemon:           t.IsDaemon,
+				Priority:           t.Priority,
+				OSPriority:         t.OSPriority,
+				StackAddress:       t.StackAddress,
+				IsCarrier:          t.IsCarrier,
+				CarrierID:          t.CarrierID,
+				MountedVirtualID:   t.MountedVirtualID,
+				IsPinned:           t.IsPinned,
+				WaitReason:         t.WaitReason,
+				WaitDuration:       t.WaitDuration,
+				LockedToThread:     t.LockedToThread,
+				RiskLevel:          t.RiskLevel,
+				Issues:             t.Issues,
+				Recommendation:     t.Recommendation,
 			}
 
 			// Append snapshot to the parent thread object
@@ -108,3 +204,59 @@ func AggregateThreads(parsedFiles []ParsedFile) []AnalyzedThread {
 
 	return result
 }
+
+// SortByCaptureTime orders parsed files chronologically. Files without a capture time keep
+// their upload order and are placed after the dated ones.
+func SortByCaptureTime(parsedFiles []ParsedFile) {
+	sort.SliceStable(parsedFiles, func(a, b int) bool {
+		timeA, timeB := parsedFiles[a].Metadata.CaptureTime, parsedFiles[b].Metadata.CaptureTime
+		if timeA.IsZero() {
+			return false
+		}
+		if timeB.IsZero() {
+			return true
+		}
+		return timeA.Before(timeB)
+	})
+}
+
+// SummarizeDumps collects the dump-level results of each parsed file in snapshot order.
+// Stacks are clustered on their top clusterDepth frames.
+func SummarizeDumps(parsedFiles []ParsedFile, settings map[string]PoolSettings, clusterDepth int) []DumpReport {
+	reports := make([]DumpReport, 0, len(parsedFiles))
+	for _, file := range parsedFiles {
+		reports = append(reports, DumpReport{
+			FileName:  file.FileName,
+			Format:    file.Format,
+			Metadata:  file.Metadata,
+			Deadlocks: file.Deadlocks,
+			Locks:     file.LockGraph,
+
+			InternalCPU: summarizeInternalCPU(file.Threads),
+			Pools:       SummarizePools(file.Threads, settings),
+			Clusters:    ClusterStacks(file.Threads, clusterDepth),
+		})
+	}
+	return reports
+}
+
+// summarizeInternalCPU groups the JVM internal threads by category, in order of first appearance
+func summarizeInternalCPU(threads []parser.Thread) []InternalCPUSummary {
+	var summaries []InternalCPUSummary
+	index := make(map[string]int)
+	for _, t := range threads {
+		if t.Kind != parser.ThreadKindJVMInternal {
+			continue
+		}
+		i, exists := index[t.InternalCategory]
+		if !exists {
+			i = len(summaries)
+			index[t.InternalCategory] = i
+			summaries = append(summaries, InternalCPUSummary{Category: t.InternalCategory})
+		}
+		summaries[i].ThreadCount++
+		summaries[i].CPUTime += t.CPUTime
+		summaries[i].CPUPercentage += t.CPUPercentage
+	}
+	return summaries
+}
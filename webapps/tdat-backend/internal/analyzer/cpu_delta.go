@@ -0,0 +1,47 @@
+package analyzer
+
+import "tdat-backend/internal/parser"
+
+// previousSample is the last snapshot of a thread seen while walking the dumps in order
+type previousSample struct {
+	file   int
+	thread *parser.Thread
+}
+
+// ComputeCPUDeltas sets the CPU used by each thread since its previous dump, Δcpu / Δwall-clock.
+// Cumulative CPU divided by the thread's lifetime hides a thread that only started spinning shortly
+// before the dump, the delta shows what it is doing now. The files must be in capture order.
+func ComputeCPUDeltas(parsedFiles []ParsedFile) {
+	previous := make(map[threadKey]previousSample)
+	for f := range parsedFiles {
+		threads := parsedFiles[f].Threads
+		for i := range threads {
+			t := &threads[i]
+			key := keyFor(*t)
+			if prev, seen := previous[key]; seen {
+				interval := sampleInterval(parsedFiles[prev.file], prev.thread, parsedFiles[f], t)
+				cpuDelta := t.CPUTime - prev.thread.CPUTime
+				// A negative delta means a new thread reused the identity
+				if interval > 0 && cpuDelta >= 0 {
+					t.CPUDeltaPercentage = cpuDelta / (interval * 1000.0) * 100.0
+					t.HasCPUDelta = true
+				}
+			}
+			previous[key] = previousSample{file: f, thread: t}
+		}
+	}
+}
+
+// sampleInterval returns the seconds between two snapshots of a thread. The growth of the thread's
+// elapsed time is preferred, it needs no capture timestamps and is exact for the thread.
+func sampleInterval(prevFile ParsedFile, prev *parser.Thread, curFile ParsedFile, cur *parser.Thread) float64 {
+	// Goroutines carry their wait duration in ElapsedTime, which restarts with each wait
+	if cur.Kind != parser.ThreadKindGoroutine && prev.ElapsedTime > 0 && cur.ElapsedTime > prev.ElapsedTime {
+		return cur.ElapsedTime - prev.ElapsedTime
+	}
+	prevTime, curTime := prevFile.Metadata.CaptureTime, curFile.Metadata.CaptureTime
+	if !prevTime.IsZero() && !curTime.IsZero() {
+		return curTime.Sub(prevTime).Seconds()
+	}
+	return 0
+}
@@ -0,0 +1,43 @@
+package analyzer
+
+import "tdat-backend/internal/parser"
+
+// DumpFact is the "dump" fact of global_rules.grl, rules report dump-level findings through it
+type DumpFact struct {
+	threads  []parser.Thread
+	Findings []Finding
+}
+
+// DumpFinding is a finding of the dump-level rules together with the dump it was found in
+type DumpFinding struct {
+	FileName string `json:"dump_name"`
+	Finding
+}
+
+// AddFinding is called by the rules to report a finding about the whole dump
+func (d *DumpFact) AddFinding(rule, severity, message, recommendation string) {
+	d.Findings = append(d.Findings, Finding{
+		Rule:           rule,
+		Severity:       severity,
+		Message:        message,
+		Recommendation: recommendation,
+	})
+}
+
+// AddStateFinding reports a finding with the threads in the given state as evidence
+func (d *DumpFact) AddStateFinding(rule, severity, message, recommendation, state string) {
+	d.AddPoolFinding(rule, severity, message, recommendation, "", state)
+}
+
+// AddPoolFinding reports a finding with the threads of a pool in the given state as evidence,
+// an empty pool or state matches every thread and "ACTIVE" the threads not idle in their pool
+func (d *DumpFact) AddPoolFinding(rule, severity, message, recommendation, pool, state string) {
+	d.AddFinding(rule, severity, message, recommendation)
+	finding := &d.Findings[len(d.Findings)-1]
+	for _, t := range d.threads {
+		inState := state == "" || t.State == state || (state == "ACTIVE" && t.Activity != ActivityIdle)
+		if (pool == "" || t.ThreadPool == pool) && inState {
+			finding.ThreadIDs = append(finding.ThreadIDs, t.ID)
+		}
+	}
+}
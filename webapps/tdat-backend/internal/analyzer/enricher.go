@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strings"
 	"tdat-backend/internal/parser"
 
 	"gopkg.in/yaml.v3"
@@ -14,6 +15,27 @@ import (
 type poolConfig struct {
 	Name     string   `yaml:"name"`
 	Patterns []string `yaml:"patterns"`
+	// Matched against the function that created a goroutine, goroutines have no meaningful names
+	CreatedBy []string `yaml:"created_by"`
+	// Optional capacity metadata
+	MaxSize    int            `yaml:"max_size"`    // Configured maximum number of threads
+	IdleFrames []string       `yaml:"idle_frames"` // Idle frames of this pool beyond the idle signatures of activities.yaml
+	Owner      string         `yaml:"owner"`       // Owning component or team
+	Thresholds poolThresholds `yaml:"thresholds"`
+}
+
+// poolThresholds override the defaults of the pool rules, 0 keeps the default
+type poolThresholds struct {
+	SaturationPercent float64 `yaml:"saturation_percent"`
+	BlockedPercent    float64 `yaml:"blocked_percent"`
+}
+
+// PoolSettings is the capacity metadata of a configured pool
+type PoolSettings struct {
+	MaxSize             int
+	Owner               string
+	SaturationThreshold float64
+	BlockedThreshold    float64
 }
 
 type threadPoolsConfig struct {
@@ -24,13 +46,16 @@ type threadPoolsConfig struct {
 
 // Pre-compiled regexes for performance
 type compiledPool struct {
-	Name    string
-	RegExps []*regexp.Regexp
+	Name           string
+	RegExps        []*regexp.Regexp
+	CreatorRegExps []*regexp.Regexp
+	IdleFrames     []string
 }
 
 // ThreadEnricher handles loading config and applying matches
 type ThreadEnricher struct {
 	compiledPools []compiledPool
+	settings      map[string]PoolSettings
 }
 
 // NewThreadEnricher function loads the rules and compiles regexes
@@ -49,8 +74,18 @@ func NewThreadEnricher(configPath string) (*ThreadEnricher, error) {
 
 	// Compile regexes
 	var compiledPools []compiledPool
+	settings := make(map[string]PoolSettings)
 	for _, poolCfg := range config.Pools {
-		cp := compiledPool{Name: poolCfg.Name}
+		cp := compiledPool{Name: poolCfg.Name, IdleFrames: poolCfg.IdleFrames}
+		if poolCfg.MaxSize < 0 {
+			return nil, fmt.Errorf("max_size of pool '%s' must not be negative", poolCfg.Name)
+		}
+		settings[poolCfg.Name] = PoolSettings{
+			MaxSize:             poolCfg.MaxSize,
+			Owner:               poolCfg.Owner,
+			SaturationThreshold: poolCfg.Thresholds.SaturationPercent,
+			BlockedThreshold:    poolCfg.Thresholds.BlockedPercent,
+		}
 		for _, pattern := range poolCfg.Patterns {
 			re, err := regexp.Compile(pattern)
 			if err != nil {
@@ -58,14 +93,27 @@ func NewThreadEnricher(configPath string) (*ThreadEnricher, error) {
 			}
 			cp.RegExps = append(cp.RegExps, re)
 		}
+		for _, pattern := range poolCfg.CreatedBy {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid created_by regex '%s' for pool '%s': %w", pattern, poolCfg.Name, err)
+			}
+			cp.CreatorRegExps = append(cp.CreatorRegExps, re)
+		}
 		compiledPools = append(compiledPools, cp)
 	}
 
 	return &ThreadEnricher{
 		compiledPools: compiledPools,
+		settings:      settings,
 	}, nil
 }
 
+// Settings returns the capacity metadata of the configured pools by pool name
+func (te *ThreadEnricher) Settings() map[string]PoolSettings {
+	return te.settings
+}
+
 // Enrich iterates through threads and categorizes them in-place.
 func (te *ThreadEnricher) Enrich(threads []parser.Thread) {
 	for i := range threads {
@@ -82,14 +130,48 @@ func (te *ThreadEnricher) Enrich(threads []parser.Thread) {
 					break // Stop regex loop for this pool
 				}
 			}
+			if !matched && t.CreatedBy != "" {
+				for _, re := range pool.CreatorRegExps {
+					if re.MatchString(t.CreatedBy) {
+						t.ThreadPool = pool.Name
+						matched = true
+						break
+					}
+				}
+			}
 			if matched {
+				t.IsPoolIdle = inIdleFrame(t, pool.IdleFrames)
 				break // Stop pool loop for this thread found match
 			}
 		}
 
 		// Fallback for threads that don't match any define pool
 		if !matched {
-			t.ThreadPool = "Other / Standalone"
+			if t.IsVirtual {
+				t.ThreadPool = "Virtual Threads"
+			} else if t.Kind == parser.ThreadKindJVMInternal {
+				t.ThreadPool = "JVM Internal"
+			} else if t.Kind == parser.ThreadKindGoroutine {
+				t.ThreadPool = "Goroutines"
+			} else {
+				t.ThreadPool = "Other / Standalone"
+			}
+		}
+	}
+}
+
+// inIdleFrame reports a worker waiting for its next task, idle frames match frames by qualified name prefix
+func inIdleFrame(t *parser.Thread, idleFrames []string) bool {
+	if t.State != "WAITING" && t.State != "TIMED_WAITING" {
+		return false
+	}
+	for _, f := range t.Frames {
+		name := f.QualifiedName()
+		for _, idle := range idleFrames {
+			if strings.HasPrefix(name, idle) {
+				return true
+			}
 		}
 	}
+	return false
 }
@@ -0,0 +1,11 @@
+package analyzer
+
+// Finding is a result of an analysis that looks beyond a single thread snapshot, across dumps or over a whole dump
+type Finding struct {
+	Rule           string   `json:"rule"`
+	Severity       string   `json:"severity"` // "CRITICAL", "HIGH", "MEDIUM", "INFO"
+	Message        string   `json:"message"`
+	Recommendation string   `json:"recommendation,omitempty"`
+	Evidence       []string `json:"evidence,omitempty"`
+	ThreadIDs      []string `json:"thread_ids,omitempty"` // Threads the finding is about
+}
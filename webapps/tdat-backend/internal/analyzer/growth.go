@@ -0,0 +1,214 @@
+package analyzer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	//Captures the executor number of "pool-N-thread-M" names, it counts executors created by the JVM
+	executorIndexRE = regexp.MustCompile(`pool-(\d+)-thread-\d+$`)
+	//Captures the trailing number of a thread name
+	nameIndexRE = regexp.MustCompile(`(\d+)$`)
+)
+
+// GrowthReport follows the thread count of the whole process and of each pool across the dumps
+type GrowthReport struct {
+	Dumps    []DumpThreadCount `json:"dumps"`
+	Pools    []PoolGrowth      `json:"pools"`
+	Findings []Finding         `json:"findings,omitempty"`
+
+	span time.Duration // Between the first and the last dump, 0 when the dumps carry no capture time
+}
+
+// DumpThreadCount is the thread count of one dump, with the threads that came and went since the previous one
+type DumpThreadCount struct {
+	FileName    string    `json:"dump_name"`
+	CapturedAt  time.Time `json:"captured_at,omitzero"`
+	ThreadCount int       `json:"thread_count"`
+	New         int       `json:"new"`
+	Disappeared int       `json:"disappeared"`
+	GrowthPct   float64   `json:"growth_percent"` // Change of the thread count since the first dump
+}
+
+// PoolGrowth is the history of one pool, Counts and MaxNameIndex have one entry per dump.
+// It is the "pool" fact of pool_growth_rules.grl.
+type PoolGrowth struct {
+	Pool            string  `json:"pool"`
+	Counts          []int   `json:"counts"`
+	FirstCount      int     `json:"-"`
+	LastCount       int     `json:"-"`
+	GrowthPerMinute float64 `json:"growth_per_minute,omitempty"` // 0 when the dumps carry no capture time
+	New             int     `json:"new"`                         // Threads of the last dump missing from the first
+	Disappeared     int     `json:"disappeared"`                 // Threads of the first dump missing from the last
+	// Highest number in the thread names of each dump, names counting up mean threads are not reused
+	MaxNameIndex      []int `json:"max_name_index,omitempty"`
+	IndicesIncreasing bool  `json:"indices_increasing,omitempty"`
+	FirstNameIndex    int   `json:"-"`
+	LastNameIndex     int   `json:"-"`
+}
+
+// GrowthFact is the "growth" fact of growth_rules.grl and pool_growth_rules.grl, rules report findings through it
+type GrowthFact struct {
+	report            *GrowthReport
+	FirstThreadCount  int
+	LastThreadCount   int
+	ThreadCountGrowth float64 // Percent change of the thread count from the first to the last dump
+}
+
+// AddFinding is called by the rules to report a finding about the whole process
+func (g *GrowthFact) AddFinding(rule, severity, message, recommendation string) {
+	g.report.Findings = append(g.report.Findings, Finding{
+		Rule:           rule,
+		Severity:       severity,
+		Message:        message,
+		Recommendation: recommendation,
+	})
+}
+
+// AddPoolFinding reports a finding with the history of the pool as evidence
+func (g *GrowthFact) AddPoolFinding(rule, severity, message, recommendation, pool string) {
+	g.AddFinding(rule, severity, message, recommendation)
+	finding := &g.report.Findings[len(g.report.Findings)-1]
+	for _, pg := range g.report.Pools {
+		if pg.Pool != pool {
+			continue
+		}
+		finding.Evidence = append(finding.Evidence, fmt.Sprintf("counts per dump: %v", pg.Counts), fmt.Sprintf("%d new, %d disappeared", pg.New, pg.Disappeared))
+		if pg.IndicesIncreasing {
+			finding.Evidence = append(finding.Evidence, fmt.Sprintf("highest name index per dump: %v", pg.MaxNameIndex))
+		}
+	}
+	if g.report.span > 0 {
+		finding.Evidence = append(finding.Evidence, "over "+g.report.span.Round(time.Second).String())
+	}
+}
+
+/* Thread Growth */
+
+// ComputeGrowth compares the dumps in capture order. It also sets ThreadCountGrowth on each dump
+// from its entry in the report so the rules can use it, and returns nil for a single dump.
+func ComputeGrowth(parsedFiles []ParsedFile) *GrowthReport {
+	if len(parsedFiles) < 2 {
+		return nil
+	}
+	report := &GrowthReport{}
+	n := len(parsedFiles)
+
+	pools := make(map[string]*PoolGrowth)
+	var poolOrder []string
+	var previous map[threadKey]bool
+	for f, file := range parsedFiles {
+		current := make(map[threadKey]bool, len(file.Threads))
+		for _, t := range file.Threads {
+			current[keyFor(t)] = true
+
+			pg, exists := pools[t.ThreadPool]
+			if !exists {
+				pg = &PoolGrowth{Pool: t.ThreadPool, Counts: make([]int, n), MaxNameIndex: make([]int, n)}
+				pools[t.ThreadPool] = pg
+				poolOrder = append(poolOrder, t.ThreadPool)
+			}
+			pg.Counts[f]++
+			if idx, ok := nameIndex(t.Name); ok && idx > pg.MaxNameIndex[f] {
+				pg.MaxNameIndex[f] = idx
+			}
+		}
+
+		count := DumpThreadCount{FileName: file.FileName, CapturedAt: file.Metadata.CaptureTime, ThreadCount: len(file.Threads)}
+		if previous != nil {
+			count.New, count.Disappeared = diffKeys(previous, current)
+		}
+		if first := len(parsedFiles[0].Threads); first > 0 {
+			count.GrowthPct = float64(len(file.Threads)-first) / float64(first) * 100.0
+		}
+		file.ThreadCountGrowth = count.GrowthPct
+		report.Dumps = append(report.Dumps, count)
+		previous = current
+	}
+
+	first, last := parsedFiles[0], parsedFiles[n-1]
+	report.span = captureSpan(first, last)
+	for _, name := range poolOrder {
+		pg := pools[name]
+		pg.New, pg.Disappeared = diffKeys(poolKeys(first, name), poolKeys(last, name))
+		pg.FirstCount, pg.LastCount = pg.Counts[0], pg.Counts[n-1]
+		pg.FirstNameIndex, pg.LastNameIndex = pg.MaxNameIndex[0], pg.MaxNameIndex[n-1]
+		pg.IndicesIncreasing = increasing(pg.MaxNameIndex)
+		if !pg.IndicesIncreasing {
+			pg.MaxNameIndex = nil
+		}
+		if report.span > 0 {
+			pg.GrowthPerMinute = float64(pg.LastCount-pg.FirstCount) / report.span.Minutes()
+		}
+		report.Pools = append(report.Pools, *pg)
+	}
+	return report
+}
+
+// Fact builds the "growth" fact of the growth rules
+func (r *GrowthReport) Fact() *GrowthFact {
+	first, last := r.Dumps[0], r.Dumps[len(r.Dumps)-1]
+	return &GrowthFact{
+		report:            r,
+		FirstThreadCount:  first.ThreadCount,
+		LastThreadCount:   last.ThreadCount,
+		ThreadCountGrowth: last.GrowthPct,
+	}
+}
+
+// nameIndex reads the executor number of "pool-N-thread-M" names, otherwise the trailing number
+func nameIndex(name string) (int, bool) {
+	m := executorIndexRE.FindStringSubmatch(name)
+	if len(m) < 2 {
+		m = nameIndexRE.FindStringSubmatch(name)
+	}
+	if len(m) < 2 {
+		return 0, false
+	}
+	idx, err := strconv.Atoi(m[1])
+	return idx, err == nil
+}
+
+// increasing reports indices that grow from dump to dump, a pool absent from a dump breaks the series
+func increasing(indices []int) bool {
+	for i := 1; i < len(indices); i++ {
+		if indices[i-1] == 0 || indices[i] <= indices[i-1] {
+			return false
+		}
+	}
+	return len(indices) >= 2
+}
+
+func diffKeys(before, after map[threadKey]bool) (added, removed int) {
+	for k := range after {
+		if !before[k] {
+			added++
+		}
+	}
+	for k := range before {
+		if !after[k] {
+			removed++
+		}
+	}
+	return added, removed
+}
+
+func poolKeys(file ParsedFile, pool string) map[threadKey]bool {
+	keys := make(map[threadKey]bool)
+	for _, t := range file.Threads {
+		if t.ThreadPool == pool {
+			keys[keyFor(t)] = true
+		}
+	}
+	return keys
+}
+
+func captureSpan(first, last ParsedFile) time.Duration {
+	if first.Metadata.CaptureTime.IsZero() || last.Metadata.CaptureTime.IsZero() {
+		return 0
+	}
+	return last.Metadata.CaptureTime.Sub(first.Metadata.CaptureTime)
+}
@@ -0,0 +1,101 @@
+package analyzer
+
+import "tdat-backend/internal/parser"
+
+// Defaults of the pool rule thresholds, thread_pools.yaml may override them per pool
+const (
+	DefaultSaturationThreshold = 90.0 // Busy threads as percent of max_size
+	DefaultBlockedThreshold    = 50.0 // BLOCKED threads as percent of the pool
+)
+
+// PoolStats summarises the threads of one pool in one dump, it is also the "pool" fact of pool_rules.grl
+type PoolStats struct {
+	Pool        string         `json:"pool"`
+	Owner       string         `json:"owner,omitempty"`
+	ThreadCount int            `json:"thread_count"`
+	States      map[string]int `json:"states"`
+	Activities  map[string]int `json:"activities,omitempty"`
+	// Capacity, threads with the idle activity are idle, all others are busy
+	MaxSize              int     `json:"max_size,omitempty"`
+	IdleCount            int     `json:"idle_count"`
+	ActiveCount          int     `json:"active_count"`
+	SaturationPercentage float64 `json:"saturation_percent,omitempty"` // ActiveCount / MaxSize, 0 without max_size
+	SaturationThreshold  float64 `json:"-"`
+	BlockedThreshold     float64 `json:"-"`
+	// Shares of the thread count, WaitingPercentage includes TIMED_WAITING
+	RunnablePercentage float64 `json:"runnable_percent"`
+	BlockedPercentage  float64 `json:"blocked_percent"`
+	WaitingPercentage  float64 `json:"waiting_percent"`
+	TotalCPUPercentage float64 `json:"total_cpu_percent"`
+	MaxCPUPercentage   float64 `json:"max_cpu_percent"`
+	// Most common top frame, e.g. "java.net.SocketInputStream.socketRead0"
+	TopFrame           string  `json:"top_frame,omitempty"`
+	TopFrameCount      int     `json:"top_frame_count,omitempty"`
+	TopFramePercentage float64 `json:"top_frame_percent,omitempty"`
+}
+
+/* Pool Statistics */
+
+// SummarizePools builds the statistics of each pool of a dump, in order of first appearance.
+// Settings carry the capacity metadata of configured pools.
+func SummarizePools(threads []parser.Thread, settings map[string]PoolSettings) []PoolStats {
+	var pools []PoolStats
+	index := make(map[string]int)
+	topFrames := make(map[string]map[string]int) // pool -> top frame -> threads
+
+	for _, t := range threads {
+		i, exists := index[t.ThreadPool]
+		if !exists {
+			i = len(pools)
+			index[t.ThreadPool] = i
+			pools = append(pools, PoolStats{Pool: t.ThreadPool, States: make(map[string]int), Activities: make(map[string]int)})
+			topFrames[t.ThreadPool] = make(map[string]int)
+		}
+		p := &pools[i]
+		p.ThreadCount++
+		p.States[t.State]++
+		if t.Activity != "" {
+			p.Activities[t.Activity]++
+		}
+		if t.Activity == ActivityIdle {
+			p.IdleCount++
+		}
+		p.TotalCPUPercentage += t.CPUPercentage
+		if t.CPUPercentage > p.MaxCPUPercentage {
+			p.MaxCPUPercentage = t.CPUPercentage
+		}
+		if len(t.Frames) > 0 {
+			topFrames[t.ThreadPool][t.Frames[0].QualifiedName()]++
+		}
+	}
+
+	for i := range pools {
+		p := &pools[i]
+		total := float64(p.ThreadCount)
+		cfg := settings[p.Pool]
+		p.Owner, p.MaxSize = cfg.Owner, cfg.MaxSize
+		p.ActiveCount = p.ThreadCount - p.IdleCount
+		if p.MaxSize > 0 {
+			p.SaturationPercentage = float64(p.ActiveCount) / float64(p.MaxSize) * 100.0
+		}
+		p.SaturationThreshold, p.BlockedThreshold = cfg.SaturationThreshold, cfg.BlockedThreshold
+		if p.SaturationThreshold <= 0 {
+			p.SaturationThreshold = DefaultSaturationThreshold
+		}
+		if p.BlockedThreshold <= 0 {
+			p.BlockedThreshold = DefaultBlockedThreshold
+		}
+		p.RunnablePercentage = float64(p.States["RUNNABLE"]) / total * 100.0
+		p.BlockedPercentage = float64(p.States["BLOCKED"]) / total * 100.0
+		p.WaitingPercentage = float64(p.States["WAITING"]+p.States["TIMED_WAITING"]) / total * 100.0
+
+		for frame, count := range topFrames[p.Pool] {
+			// Ties go to the alphabetically first frame so the result does not depend on map order
+			if count > p.TopFrameCount || (count == p.TopFrameCount && frame < p.TopFrame) {
+				p.TopFrame, p.TopFrameCount = frame, count
+			}
+		}
+		p.TopFramePercentage = float64(p.TopFrameCount) / total * 100.0
+	}
+	return pools
+}
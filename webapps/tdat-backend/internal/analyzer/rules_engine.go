@@ -1,6 +1,7 @@
 package analyzer
 
 import (
+	"path/filepath"
 	"tdat-backend/internal/parser"
 
 	"github.com/hyperjumptech/grule-rule-engine/ast"
@@ -13,15 +14,29 @@ type RuleEngine struct {
 	KnowledgeLibrary *ast.KnowledgeLibrary
 }
 
-// NewEngine initializes Grule and loads the GRL file
-func NewEngine(ruleFilePath string) (*RuleEngine, error) {
+// Rule files of the rules directory and the knowledge base each is loaded into
+var ruleFiles = []struct {
+	knowledgeBase string
+	fileName      string
+}{
+	{"ThreadRules", "rules.grl"},                 // Once per thread, facts "t" and "global"
+	{"GlobalRules", "global_rules.grl"},          // Once per dump, facts "global" and "dump"
+	{"PoolRules", "pool_rules.grl"},              // Once per pool of a dump, facts "pool", "global" and "dump"
+	{"GrowthRules", "growth_rules.grl"},          // Once per upload of several dumps, fact "growth"
+	{"PoolGrowthRules", "pool_growth_rules.grl"}, // Once per pool across the dumps, facts "pool" and "growth"
+}
+
+// NewEngine initializes Grule and loads the GRL files of the rules directory into separate knowledge bases
+func NewEngine(rulesDir string) (*RuleEngine, error) {
 	lib := ast.NewKnowledgeLibrary()
 	ruleBuilder := builder.NewRuleBuilder(lib)
 
 	// Load the rules from the file system
-	err := ruleBuilder.BuildRuleFromResource("ThreadRules", "0.0.1", pkg.NewFileResource(ruleFilePath))
-	if err != nil {
-		return nil, err
+	for _, rf := range ruleFiles {
+		err := ruleBuilder.BuildRuleFromResource(rf.knowledgeBase, "0.0.1", pkg.NewFileResource(filepath.Join(rulesDir, rf.fileName)))
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &RuleEngine{
@@ -29,63 +44,183 @@ func NewEngine(ruleFilePath string) (*RuleEngine, error) {
 	}, nil
 }
 
-// AnalyzeThreads applies the rules to a slice of threads
-func (e *RuleEngine) AnalyzeThreads(threads []parser.Thread, usageDataProvided bool) error {
+// AnalyzeThreads applies the rules to the threads of a dump
+func (e *RuleEngine) AnalyzeThreads(dump *parser.ThreadDump) error {
+	threads := dump.Threads
+
 	// Get the KnowledgeBase from the library
 	kb, err := e.KnowledgeLibrary.NewKnowledgeBaseInstance("ThreadRules", "0.0.1")
 	if err != nil {
 		return err
 	}
 
-	if !usageDataProvided {
-		// If no external usage file, infer CPU from header attributes with the formula (CPUTimeMS / ElapsedTimeMS) * 100
-		for i := range threads {
-			t := &threads[i]
-			// Ensure elapsed time is > 0 to avoid division by zeroNaN
-			// ElapsedTime is s, convert to ms.
-			elapsedMs := t.ElapsedTime * 1000.0
-			if elapsedMs > 0 && t.CPUTime > 0 {
-				t.CPUPercentage = (t.CPUTime / elapsedMs) * 100.0
-			} else {
-				t.CPUPercentage = 0.0
-			}
+	// If the usage file gave no CPU percentage for a thread, infer it from header attributes with the formula
+	// (CPUTimeMS / ElapsedTimeMS) * 100. A single /proc task stat capture only carries CPU times.
+	for i := range threads {
+		t := &threads[i]
+		if t.HasUsageCPU {
+			continue
+		}
+		// Ensure elapsed time is > 0 to avoid division by zeroNaN
+		// ElapsedTime is s, convert to ms.
+		elapsedMs := t.ElapsedTime * 1000.0
+		if elapsedMs > 0 && t.CPUTime > 0 {
+			t.CPUPercentage = (t.CPUTime / elapsedMs) * 100.0
+		} else {
+			t.CPUPercentage = 0.0
 		}
 	}
 
+	stats := buildGlobalStats(dump)
+
+	// Run Engine Per Thread
+	for i := range threads {
+		t := &threads[i]
+
+		// Context contains BOTH the thread and the global stats
+		dataCtx := ast.NewDataContext()
+
+		// "t" and "global" must match the variable names in rules.grl
+		err := dataCtx.Add("t", t)
+		if err != nil {
+			return err
+		}
+		err = dataCtx.Add("global", stats)
+		if err != nil {
+			return err
+		}
+
+		ruleEngine := engine.NewGruleEngine()
+		if err := ruleEngine.Execute(dataCtx, kb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildGlobalStats calculates the dump-wide figures the rules compare against
+func buildGlobalStats(dump *parser.ThreadDump) *parser.GlobalStats {
+	threads := dump.Threads
+	usageDataProvided := dump.HasUsageData
+
 	// Calculate Global Stats
 	stats := &parser.GlobalStats{
 		TotalThreads:        len(threads),
+		ThreadCountGrowth:   dump.ThreadCountGrowth,
 		IsUsageDataProvided: usageDataProvided,
+		JavaMajorVersion:    dump.Metadata.JavaMajorVersion,
+		VMName:              dump.Metadata.VMName,
+	}
+	if mem := dump.Metadata.Memory; mem != nil {
+		stats.HeapUsedPercent = mem.HeapUsedPercent
+		stats.MetaspaceUsedKB = mem.MetaspaceUsedKB
+		stats.JNIGlobalRefs = mem.JNIGlobalRefs
 	}
 	blockedCount := 0
 	for _, t := range threads {
 		if t.State == "BLOCKED" {
 			blockedCount++
 		}
+		// Shutdown hooks or System.exit on a stack, DestroyJavaVM alone only means main returned and is normal for servers
+		if t.HasFrame("java.lang.Shutdown") || t.HasFrame("java.lang.ApplicationShutdownHooks") || t.HasFrame("java.lang.Runtime.exit") {
+			stats.ShutdownInProgress = true
+		}
+		if t.InternalCategory == parser.InternalCategoryGC {
+			stats.GCCPUPercentage += t.CPUPercentage
+		}
 	}
+	stats.BlockedCount = blockedCount
 	if len(threads) > 0 {
 		stats.BlockedPercentage = (float64(blockedCount) / float64(len(threads))) * 100.0
 	}
+	return stats
+}
 
-	// Run Engine Per Thread
-	for i := range threads {
-		t := &threads[i]
+// AnalyzeDump applies the dump-level rules once per dump and the pool rules once per pool.
+// It runs after AnalyzeThreads so inferred CPU is included.
+func (e *RuleEngine) AnalyzeDump(dump *parser.ThreadDump, settings map[string]PoolSettings) ([]Finding, error) {
+	globalKB, err := e.KnowledgeLibrary.NewKnowledgeBaseInstance("GlobalRules", "0.0.1")
+	if err != nil {
+		return nil, err
+	}
+	poolKB, err := e.KnowledgeLibrary.NewKnowledgeBaseInstance("PoolRules", "0.0.1")
+	if err != nil {
+		return nil, err
+	}
 
-		// Context contains BOTH the thread and the global stats
-		dataCtx := ast.NewDataContext()
+	stats := buildGlobalStats(dump)
+	fact := &DumpFact{threads: dump.Threads}
 
-		// "t" and "global" must match the variable names in rules.grl
-		err := dataCtx.Add("t", t)
-		if err != nil {
+	// "global" and "dump" must match the variable names in global_rules.grl
+	dataCtx := ast.NewDataContext()
+	if err := dataCtx.Add("global", stats); err != nil {
+		return nil, err
+	}
+	if err := dataCtx.Add("dump", fact); err != nil {
+		return nil, err
+	}
+	if err := engine.NewGruleEngine().Execute(dataCtx, globalKB); err != nil {
+		return nil, err
+	}
+
+	// "pool", "global" and "dump" must match the variable names in pool_rules.grl
+	pools := SummarizePools(dump.Threads, settings)
+	for i := range pools {
+		poolCtx := ast.NewDataContext()
+		if err := poolCtx.Add("pool", &pools[i]); err != nil {
+			return nil, err
+		}
+		if err := poolCtx.Add("global", stats); err != nil {
+			return nil, err
+		}
+		if err := poolCtx.Add("dump", fact); err != nil {
+			return nil, err
+		}
+		before := len(fact.Findings)
+		if err := engine.NewGruleEngine().Execute(poolCtx, poolKB); err != nil {
+			return nil, err
+		}
+		if pools[i].Owner != "" {
+			for j := before; j < len(fact.Findings); j++ {
+				fact.Findings[j].Evidence = append(fact.Findings[j].Evidence, "owner: "+pools[i].Owner)
+			}
+		}
+	}
+	return fact.Findings, nil
+}
+
+// AnalyzeGrowth applies the growth rules to the thread counts of the whole process and of each pool across the dumps,
+// the findings are added to the report
+func (e *RuleEngine) AnalyzeGrowth(report *GrowthReport) error {
+	growthKB, err := e.KnowledgeLibrary.NewKnowledgeBaseInstance("GrowthRules", "0.0.1")
+	if err != nil {
+		return err
+	}
+	poolKB, err := e.KnowledgeLibrary.NewKnowledgeBaseInstance("PoolGrowthRules", "0.0.1")
+	if err != nil {
+		return err
+	}
+
+	// "growth" must match the variable name in growth_rules.grl
+	fact := report.Fact()
+	dataCtx := ast.NewDataContext()
+	if err := dataCtx.Add("growth", fact); err != nil {
+		return err
+	}
+	if err := engine.NewGruleEngine().Execute(dataCtx, growthKB); err != nil {
+		return err
+	}
+
+	// "pool" and "growth" must match the variable names in pool_growth_rules.grl
+	for i := range report.Pools {
+		poolCtx := ast.NewDataContext()
+		if err := poolCtx.Add("pool", &report.Pools[i]); err != nil {
 			return err
 		}
-		err = dataCtx.Add("global", stats)
-		if err != nil {
+		if err := poolCtx.Add("growth", fact); err != nil {
 			return err
 		}
-
-		ruleEngine := engine.NewGruleEngine()
-		if err := ruleEngine.Execute(dataCtx, kb); err != nil {
+		if err := engine.NewGruleEngine().Execute(poolCtx, poolKB); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,127 @@
+package analyzer
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"tdat-backend/internal/parser"
+)
+
+// DefaultClusterFrameDepth is how many top frames two stacks must share to fall into the same cluster
+const DefaultClusterFrameDepth = 10
+
+// MinClusterSize leaves single threads out of the clusters, they are not a group
+const MinClusterSize = 2
+
+// SharedStack is a stack trace stored once and referenced by StackID from every snapshot that has it
+type SharedStack struct {
+	ID         string              `json:"id"`
+	StackTrace []string            `json:"stack_trace"`
+	Frames     []parser.StackFrame `json:"frames"`
+	Count      int                 `json:"count"` // Snapshots referencing the stack
+}
+
+// StackCluster groups the threads of a dump whose top frames match, lock addresses aside
+type StackCluster struct {
+	ID          string         `json:"id"`
+	TopFrames   []string       `json:"top_frames"`
+	ThreadCount int            `json:"thread_count"`
+	States      map[string]int `json:"states"`
+	Members     []string       `json:"members"`   // Thread IDs
+	StackIDs    []string       `json:"stack_ids"` // Exact stacks within the cluster
+}
+
+/* Stack Deduplication */
+
+// DeduplicateStacks replaces the stacks of the snapshots by references to a shared stack table.
+// Stacks are shared when byte-identical, so each reference shows exactly what the dump holds.
+func DeduplicateStacks(threads []AnalyzedThread) []SharedStack {
+	var stacks []SharedStack
+	index := make(map[string]int)
+	for i := range threads {
+		for j := range threads[i].Snapshots {
+			s := &threads[i].Snapshots[j]
+			if len(s.StackTrace) == 0 {
+				continue
+			}
+			id := stackID(s.StackTrace)
+			k, exists := index[id]
+			if !exists {
+				k = len(stacks)
+				index[id] = k
+				stacks = append(stacks, SharedStack{ID: id, StackTrace: s.StackTrace, Frames: s.Frames})
+			}
+			stacks[k].Count++
+			s.StackID = id
+		}
+	}
+	return stacks
+}
+
+// stackID fingerprints the raw lines of a stack
+func stackID(lines []string) string {
+	sum := sha1.Sum([]byte(strings.Join(lines, "\n")))
+	return "s-" + hex.EncodeToString(sum[:6])
+}
+
+/* Stack Clustering */
+
+// ClusterStacks groups the threads of one dump by their top frames, a depth of 0 or less compares whole stacks.
+// Clusters are ordered by size, largest first.
+func ClusterStacks(threads []parser.Thread, depth int) []StackCluster {
+	var clusters []StackCluster
+	index := make(map[string]int)
+	for _, t := range threads {
+		if len(t.Frames) == 0 {
+			continue
+		}
+		top := clusterFrames(t.Frames, depth)
+		key := strings.Join(top, "\n")
+		k, exists := index[key]
+		if !exists {
+			k = len(clusters)
+			index[key] = k
+			sum := sha1.Sum([]byte(key))
+			clusters = append(clusters, StackCluster{ID: "c-" + hex.EncodeToString(sum[:6]), TopFrames: top, States: make(map[string]int)})
+		}
+		c := &clusters[k]
+		c.ThreadCount++
+		c.States[t.State]++
+		c.Members = append(c.Members, t.ID)
+		if id := stackID(t.StackTrace); !containsString(c.StackIDs, id) {
+			c.StackIDs = append(c.StackIDs, id)
+		}
+	}
+
+	var result []StackCluster
+	for _, c := range clusters {
+		if c.ThreadCount >= MinClusterSize {
+			result = append(result, c)
+		}
+	}
+	sort.SliceStable(result, func(a, b int) bool { return result[a].ThreadCount > result[b].ThreadCount })
+	return result
+}
+
+// clusterFrames renders the top frames with their lock annotations, addresses are left out so threads
+// waiting on different instances of the same lock class still match
+func clusterFrames(frames []parser.StackFrame, depth int) []string {
+	if depth > 0 && len(frames) > depth {
+		frames = frames[:depth]
+	}
+	top := make([]string, 0, len(frames))
+	for _, f := range frames {
+		line := f.QualifiedName()
+		if f.Line > 0 {
+			line = fmt.Sprintf("%s:%d", line, f.Line)
+		}
+		for _, lock := range f.Locks {
+			line += " [" + lock.Action + " " + lock.Class + "]"
+		}
+		top = append(top, line)
+	}
+	return top
+}
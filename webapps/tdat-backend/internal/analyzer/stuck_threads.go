@@ -0,0 +1,117 @@
+package analyzer
+
+import (
+	"fmt"
+	"time"
+
+	"tdat-backend/internal/parser"
+)
+
+// Defaults of the stuck thread detection
+const (
+	DefaultStuckFrameDepth = 5               // Top frames compared between snapshots
+	DefaultStuckMinSpan    = 2 * time.Minute // Time the stack must stay unchanged
+)
+
+// StuckOptions tunes DetectStuckThreads
+type StuckOptions struct {
+	FrameDepth int
+	MinSpan    time.Duration
+}
+
+// Thread states in which an unchanged stack means a hung request
+var stuckStates = map[string]string{
+	"RUNNABLE":      "HIGH",
+	"BLOCKED":       "HIGH",
+	"WAITING":       "MEDIUM",
+	"TIMED_WAITING": "MEDIUM",
+}
+
+/* Stuck Thread Detection */
+
+// DetectStuckThreads flags threads whose top frames stayed the same in every snapshot over at least
+// MinSpan. It runs on the aggregated history, the rules engine only ever sees one snapshot.
+func DetectStuckThreads(threads []AnalyzedThread, opts StuckOptions) {
+	if opts.FrameDepth <= 0 {
+		opts.FrameDepth = DefaultStuckFrameDepth
+	}
+	if opts.MinSpan <= 0 {
+		opts.MinSpan = DefaultStuckMinSpan
+	}
+
+	for i := range threads {
+		t := &threads[i]
+		if t.Kind == parser.ThreadKindJVMInternal || len(t.Snapshots) < 2 {
+			continue
+		}
+		first, last := t.Snapshots[0], t.Snapshots[len(t.Snapshots)-1]
+		top := topFrames(first.Frames, opts.FrameDepth)
+		// An unchanged idle stack is a worker waiting for its next task, not a hang
+		if len(top) == 0 || first.Activity == ActivityIdle {
+			continue
+		}
+
+		severity, stuckState := stuckStates[first.State]
+		for _, s := range t.Snapshots[1:] {
+			if !stuckState || s.State != first.State || !sameFrames(top, topFrames(s.Frames, opts.FrameDepth)) {
+				stuckState = false
+				break
+			}
+		}
+		if !stuckState {
+			continue
+		}
+		span := snapshotSpan(first, last)
+		if span < opts.MinSpan {
+			continue
+		}
+
+		t.Findings = append(t.Findings, Finding{
+			Rule:     "StuckThread",
+			Severity: severity,
+			Message: fmt.Sprintf("Stack unchanged in %s across %d dumps over %s",
+				first.State, len(t.Snapshots), span.Round(time.Second)),
+			Recommendation: "The thread has not progressed, look for a hung request: a remote call without timeout, a lock never released or a loop that does not end.",
+			Evidence:       top,
+		})
+	}
+}
+
+// topFrames renders the top frames including the line, so a loop moving between lines is not stuck
+func topFrames(frames []parser.StackFrame, depth int) []string {
+	if len(frames) > depth {
+		frames = frames[:depth]
+	}
+	top := make([]string, 0, len(frames))
+	for _, f := range frames {
+		if f.Line > 0 {
+			top = append(top, fmt.Sprintf("%s:%d", f.QualifiedName(), f.Line))
+		} else {
+			top = append(top, f.QualifiedName())
+		}
+	}
+	return top
+}
+
+func sameFrames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// snapshotSpan measures the time between two snapshots by capture time, or else by the growth of the elapsed time
+func snapshotSpan(first, last ThreadSnapshot) time.Duration {
+	if !first.CapturedAt.IsZero() && !last.CapturedAt.IsZero() {
+		return last.CapturedAt.Sub(first.CapturedAt)
+	}
+	if last.ElapsedTime > first.ElapsedTime {
+		return time.Duration((last.ElapsedTime - first.ElapsedTime) * float64(time.Second))
+	}
+	return 0
+}
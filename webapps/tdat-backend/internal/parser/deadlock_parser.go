@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DeadlockParticipant is one thread of a deadlock cycle reported by the JVM
+type DeadlockParticipant struct {
+	ThreadName      string `json:"thread_name"`
+	WaitingFor      string `json:"waiting_for"` // Object address of the lock the thread wants
+	WaitingForClass string `json:"waiting_for_class"`
+	Holding         string `json:"holding,omitempty"` // Object address of the lock in the cycle the thread holds
+	HoldingClass    string `json:"holding_class,omitempty"`
+	HeldBy          string `json:"held_by"` // Name of the thread that holds WaitingFor
+}
+
+// Deadlock is a single cycle from a "Found one Java-level deadlock" section
+type Deadlock struct {
+	Participants []DeadlockParticipant `json:"participants"`
+}
+
+var (
+	//Captures the banner that starts a deadlock report
+	deadlockStartRE = regexp.MustCompile(`^Found one Java-level deadlock:`)
+	//Captures the summary line that ends the deadlock reports
+	deadlockEndRE = regexp.MustCompile(`^Found (\d+|one|a total of \d+) deadlocks?\.`)
+	//Captures a participant name line, e.g. "Thread-0":
+	deadlockThreadRE = regexp.MustCompile(`^"(.+)":\s*$`)
+	//Captures a wanted monitor: object address and class
+	deadlockMonitorRE = regexp.MustCompile(`waiting to lock monitor \S+\s*\(object (0x[0-9a-fA-F]+), a ([^)]+)\)`)
+	//Captures a wanted j.u.c. synchronizer: address and class
+	deadlockSynchronizerRE = regexp.MustCompile(`waiting for ownable synchronizer (0x[0-9a-fA-F]+),?\s*\(a ([^)]+)\)`)
+	//Captures the name of the thread holding the wanted lock
+	deadlockHeldByRE = regexp.MustCompile(`which is held by "(.+)"`)
+)
+
+/* Parsing Deadlock Reports */
+
+// deadlockSectionParser collects the cycles listed after the thread entries of a HotSpot dump
+type deadlockSectionParser struct {
+	active      bool
+	inStacks    bool // Inside "Java stack information", which repeats stacks already parsed
+	deadlocks   []Deadlock
+	current     *Deadlock
+	participant *DeadlockParticipant
+}
+
+// consume handles one line and reports whether it belonged to a deadlock section
+func (p *deadlockSectionParser) consume(line string) bool {
+	if deadlockStartRE.MatchString(line) {
+		p.flush()
+		p.active = true
+		p.inStacks = false
+		p.current = &Deadlock{}
+		return true
+	}
+	if !p.active {
+		return false
+	}
+
+	if deadlockEndRE.MatchString(line) {
+		p.flush()
+		p.active = false
+		return true
+	}
+	if strings.HasPrefix(line, "Java stack information for the threads listed above") {
+		p.flushParticipant()
+		p.inStacks = true
+		return true
+	}
+	if p.inStacks || p.current == nil {
+		return true
+	}
+
+	// A new participant starts with its quoted name
+	if m := deadlockThreadRE.FindStringSubmatch(line); len(m) >= 2 {
+		p.flushParticipant()
+		p.participant = &DeadlockParticipant{ThreadName: m[1]}
+		return true
+	}
+	if p.participant == nil {
+		return true
+	}
+
+	if m := deadlockMonitorRE.FindStringSubmatch(line); len(m) >= 3 {
+		p.participant.WaitingFor = m[1]
+		p.participant.WaitingForClass = strings.TrimSpace(m[2])
+	} else if m := deadlockSynchronizerRE.FindStringSubmatch(line); len(m) >= 3 {
+		p.participant.WaitingFor = m[1]
+		p.participant.WaitingForClass = strings.TrimSpace(m[2])
+	} else if m := deadlockHeldByRE.FindStringSubmatch(line); len(m) >= 2 {
+		p.participant.HeldBy = m[1]
+	}
+	return true
+}
+
+func (p *deadlockSectionParser) flushParticipant() {
+	if p.current != nil && p.participant != nil {
+		p.current.Participants = append(p.current.Participants, *p.participant)
+	}
+	p.participant = nil
+}
+
+func (p *deadlockSectionParser) flush() {
+	p.flushParticipant()
+	if p.current != nil && len(p.current.Participants) > 0 {
+		linkHeldLocks(p.current)
+		p.deadlocks = append(p.deadlocks, *p.current)
+	}
+	p.current = nil
+}
+
+// linkHeldLocks fills in the lock each participant holds, which is the lock its predecessor in the cycle wants
+func linkHeldLocks(d *Deadlock) {
+	for i := range d.Participants {
+		holder := &d.Participants[i]
+		for _, waiter := range d.Participants {
+			if waiter.HeldBy == holder.ThreadName {
+				holder.Holding = waiter.WaitingFor
+				holder.HoldingClass = waiter.WaitingForClass
+				break
+			}
+		}
+	}
+}
+
+// markDeadlockedThreads flags every thread named in one of the reported cycles
+func markDeadlockedThreads(threads []Thread, deadlocks []Deadlock) {
+	names := make(map[string]bool)
+	for _, d := range deadlocks {
+		for _, p := range d.Participants {
+			names[p.ThreadName] = true
+		}
+	}
+	for i := range threads {
+		if names[threads[i].Name] {
+			threads[i].IsDeadlocked = true
+		}
+	}
+}
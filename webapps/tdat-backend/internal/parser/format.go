@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+)
+
+// Dump formats recognised by ProcessAndCorrelate
+const (
+	FormatHotSpot   = "hotspot"   // Classic jstack / kill -3 text layout
+	FormatJSON      = "jdk-json"  // jcmd Thread.dump_to_file -format=json (JDK 21+)
+	FormatJavacore  = "javacore"  // IBM J9 / OpenJ9 javacore.txt
+	FormatGoroutine = "goroutine" // Go traceback, debug.Stack or pprof goroutine?debug=2
+)
+
+var (
+	//Captures a goroutine header line, panics print a message above the first one
+	goroutineDetectRE = regexp.MustCompile(`^goroutine \d+ .*\[[^\]]+\]:\s*$`)
+)
+
+// formatScanLimit is how far into a dump DetectFormat looks for a recognisable header, log output or a long
+// panic message may come first. Readers passed to DetectFormat need a buffer of this size.
+const formatScanLimit = 1 << 20
+
+/* Format Detection */
+
+// DetectFormat scans the start of a dump line by line for the first recognisable header without consuming it,
+// a dump without one is read as HotSpot.
+func DetectFormat(r *bufio.Reader) string {
+	head, _ := r.Peek(min(formatScanLimit, r.Size()))
+	// Skip a UTF-8 byte order mark and leading whitespace
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	head = bytes.TrimLeft(head, " \t\r\n")
+
+	if bytes.HasPrefix(head, []byte("{")) {
+		return FormatJSON
+	}
+	for line := range bytes.Lines(head) {
+		switch {
+		// Javacores are made of tagged lines and open with a section banner
+		case bytes.HasPrefix(line, []byte("0SECTION")):
+			return FormatJavacore
+		case goroutineDetectRE.Match(line):
+			return FormatGoroutine
+		case dumpBannerRE.Match(line), threadHeaderRE.Match(line) && nidRE.Match(line):
+			return FormatHotSpot
+		}
+	}
+	return FormatHotSpot
+}
@@ -0,0 +1,183 @@
+package parser
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	//Captures goroutine id and the bracketed status, Go 1.23+ may print "gp=... m=... mp=..." before it
+	goroutineHeaderRE = regexp.MustCompile(`^goroutine (\d+)(?: gp=\S+ m=\S+(?: mp=\S+)?)? \[([^\]]+)\]:\s*$`)
+	//Captures file and line of the frame above, e.g. "	/usr/local/go/src/net/http/server.go:1995 +0x612"
+	goFrameLocationRE = regexp.MustCompile(`^\s+(\S+?):(\d+)(?:\s+\+0x[0-9a-fA-F]+)?\s*$`)
+	//Captures the creating function and, from Go 1.21, the creating goroutine
+	goCreatedByRE = regexp.MustCompile(`^created by (\S+?)(?: in goroutine (\d+))?\s*$`)
+	//Captures the wait duration part of the status, e.g. "5 minutes"
+	goWaitMinutesRE = regexp.MustCompile(`^(\d+) minutes?$`)
+)
+
+// goroutineStates maps Go wait reasons onto java.lang.Thread.State names so the rules apply to both
+var goroutineStates = map[string]string{
+	"running":            "RUNNABLE",
+	"runnable":           "RUNNABLE",
+	"syscall":            "RUNNABLE",
+	"sleep":              "TIMED_WAITING",
+	"semacquire":         "BLOCKED",
+	"sync.Mutex.Lock":    "BLOCKED",
+	"sync.RWMutex.Lock":  "BLOCKED",
+	"sync.RWMutex.RLock": "BLOCKED",
+	"dead":               "TERMINATED",
+}
+
+/* Parsing Go Goroutine Dumps */
+
+// ParseGoroutineDump reads the goroutine traces printed on SIGQUIT or a panic, by debug.Stack
+// and by /debug/pprof/goroutine?debug=2. Goroutines are mapped onto the common thread model.
+func ParseGoroutineDump(r io.Reader) (*ThreadDump, error) {
+	var threads []Thread
+	var currentThread *Thread
+	var pendingFunc string // A function line waits for the location line below it
+
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, 1024*1024)
+
+	flushThread := func() {
+		if currentThread != nil {
+			threads = append(threads, *currentThread)
+		}
+		currentThread = nil
+		pendingFunc = ""
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Check for goroutine header
+		if m := goroutineHeaderRE.FindStringSubmatch(line); len(m) >= 3 {
+			flushThread()
+			currentThread = newGoroutine(m[1], m[2])
+			continue
+		}
+		if currentThread == nil {
+			continue
+		}
+		// A blank line ends the goroutine
+		if strings.TrimSpace(line) == "" {
+			flushThread()
+			continue
+		}
+
+		// Check for the creator, printed after the last frame
+		if m := goCreatedByRE.FindStringSubmatch(line); len(m) >= 2 {
+			currentThread.CreatedBy = m[1]
+			currentThread.CreatedByGoroutine = m[2]
+			currentThread.StackTrace = append(currentThread.StackTrace, strings.TrimSpace(line))
+			pendingFunc = ""
+			continue
+		}
+
+		// Check for the location of the pending frame, the location of "created by" has none and is skipped
+		if m := goFrameLocationRE.FindStringSubmatch(line); len(m) >= 3 && pendingFunc != "" {
+			frame := parseGoFrame(pendingFunc)
+			frame.File = m[1]
+			frame.Line, _ = strconv.Atoi(m[2])
+			currentThread.Frames = append(currentThread.Frames, frame)
+			currentThread.StackTrace = append(currentThread.StackTrace, "at "+frame.QualifiedName()+"("+m[1]+":"+m[2]+")")
+			pendingFunc = ""
+			continue
+		}
+
+		// Any other unindented line is a function, "...additional frames elided..." is skipped
+		if !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, "...") {
+			pendingFunc = strings.TrimSpace(line)
+		}
+	}
+	flushThread()
+
+	return &ThreadDump{Format: FormatGoroutine, Threads: threads}, scanner.Err()
+}
+
+// newGoroutine builds a thread from a header, the status holds the wait reason followed by
+// optional "N minutes" and "locked to thread" parts
+func newGoroutine(id, status string) *Thread {
+	t := &Thread{
+		ID:         id,
+		Name:       "goroutine " + id,
+		Kind:       ThreadKindGoroutine,
+		StackTrace: []string{},
+		Frames:     []StackFrame{},
+		Issues:     []string{},
+	}
+	t.SequenceNumber, _ = strconv.Atoi(id)
+
+	parts := strings.Split(status, ", ")
+	t.WaitReason = parts[0]
+	for _, part := range parts[1:] {
+		if m := goWaitMinutesRE.FindStringSubmatch(part); len(m) >= 2 {
+			minutes, _ := strconv.Atoi(m[1])
+			t.WaitDuration = float64(minutes * 60)
+		} else if part == "locked to thread" {
+			t.LockedToThread = true
+		}
+	}
+	// Goroutines have no lifetime, the wait duration stands in so the duration rules apply
+	t.ElapsedTime = t.WaitDuration
+
+	state, known := goroutineStates[t.WaitReason]
+	if !known {
+		// Channel operations, select, IO wait, sync.Cond.Wait, GC waits ...
+		state = "WAITING"
+	}
+	t.State = state
+	return t
+}
+
+// parseGoFrame reads "net/http.(*conn).serve(0xc000123456, ...)" into package, receiver and function
+func parseGoFrame(line string) StackFrame {
+	name := trimGoArguments(line)
+	var frame StackFrame
+
+	// The package name ends at the first dot after the last slash of the import path
+	pkgEnd := strings.LastIndex(name, "/") + 1
+	dot := strings.Index(name[pkgEnd:], ".")
+	if dot < 0 {
+		frame.Method = name
+		return frame
+	}
+	frame.Package = name[:pkgEnd+dot]
+	rest := name[pkgEnd+dot+1:]
+
+	// Methods name their receiver, "(*conn).serve"
+	if strings.HasPrefix(rest, "(") {
+		if end := strings.Index(rest, ")."); end >= 0 {
+			frame.Class = rest[:end+1]
+			rest = rest[end+2:]
+		}
+	}
+	frame.Method = rest
+	return frame
+}
+
+// trimGoArguments drops the argument list, the receiver of a method also uses parentheses
+func trimGoArguments(line string) string {
+	if !strings.HasSuffix(line, ")") {
+		return line
+	}
+	depth := 0
+	for i := len(line) - 1; i >= 0; i-- {
+		switch line[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
@@ -0,0 +1,180 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// JVMMemory is the memory state printed after the threads of a kill -3 dump
+type JVMMemory struct {
+	JNIGlobalRefs int          `json:"jni_global_refs"`
+	JNIWeakRefs   int          `json:"jni_weak_refs,omitempty"`
+	Pools         []MemoryPool `json:"pools,omitempty"`
+
+	// Totals over the heap generations or regions, metaspace excluded
+	HeapCapacityKB  int64   `json:"heap_capacity_kb,omitempty"`
+	HeapUsedKB      int64   `json:"heap_used_kb,omitempty"`
+	HeapUsedPercent float64 `json:"heap_used_percent,omitempty"`
+
+	MetaspaceUsedKB      int64 `json:"metaspace_used_kb,omitempty"`
+	MetaspaceCommittedKB int64 `json:"metaspace_committed_kb,omitempty"`
+}
+
+// MemoryPool is a generation, space or metaspace line of the Heap block
+type MemoryPool struct {
+	Name        string  `json:"name"`
+	Parent      string  `json:"parent,omitempty"` // Generation a space belongs to, e.g. "eden space" of "PSYoungGen"
+	CapacityKB  int64   `json:"capacity_kb,omitempty"`
+	UsedKB      int64   `json:"used_kb,omitempty"`
+	UsedPercent float64 `json:"used_percent"`
+	CommittedKB int64   `json:"committed_kb,omitempty"`
+	ReservedKB  int64   `json:"reserved_kb,omitempty"`
+}
+
+var (
+	//Captures the JNI reference counts, "JNI global refs: 15, weak refs: 0" or "JNI global references: 15" (JDK 8)
+	jniRefsRE = regexp.MustCompile(`^JNI global (?:refs|references): (\d+)(?:, weak refs: (\d+))?`)
+	//Captures the line that opens the heap summary
+	heapStartRE = regexp.MustCompile(`^(?:Shenandoah )?Heap\s*$`)
+	//Captures a generation or heap: name, total and used, e.g. " PSYoungGen      total 76288K, used 3932K"
+	heapGenerationRE = regexp.MustCompile(`^\s+(.+?)\s+total (\d+)([KMG]), used (\d+)([KMG])`)
+	//Captures a space of the generation above: name, size and percentage used, e.g. "  eden space 65536K, 6% used"
+	heapSpaceRE = regexp.MustCompile(`^\s+(.+? space) (\d+)([KMG]), (\d+)% used`)
+	//Captures metaspace lines: name, used, optional capacity, committed and reserved
+	heapMetaspaceRE = regexp.MustCompile(`^\s+(Metaspace|class space)\s+used (\d+)([KMG]),(?: capacity (\d+)([KMG]),)? committed (\d+)([KMG]), reserved (\d+)([KMG])`)
+	//Captures the ZGC heap: used, capacity and max capacity
+	heapZRE = regexp.MustCompile(`^\s+ZHeap\s+used (\d+)([KMG]), capacity (\d+)([KMG]), max capacity (\d+)([KMG])`)
+	//Captures the Shenandoah heap: max, committed and used
+	heapShenandoahRE = regexp.MustCompile(`^\s*(\d+)([KMG]) max, (?:\d+[KMG] soft max, )?(\d+)([KMG]) committed, (\d+)([KMG]) used`)
+)
+
+/* Parsing the Heap Summary */
+
+// heapSectionParser collects the JNI references and Heap block that follow the threads of a dump
+type heapSectionParser struct {
+	active     bool
+	memory     *JVMMemory
+	generation string
+}
+
+// consume handles one line and reports whether it belonged to the memory sections
+func (p *heapSectionParser) consume(line string) bool {
+	if m := jniRefsRE.FindStringSubmatch(line); len(m) >= 2 {
+		mem := p.get()
+		mem.JNIGlobalRefs, _ = strconv.Atoi(m[1])
+		mem.JNIWeakRefs, _ = strconv.Atoi(m[2])
+		return true
+	}
+	if heapStartRE.MatchString(line) {
+		p.get()
+		p.active = true
+		p.generation = ""
+		return true
+	}
+	if !p.active {
+		return false
+	}
+	if strings.TrimSpace(line) == "" {
+		p.active = false
+		return true
+	}
+	// Lines of the block are indented, anything else belongs to what follows the block
+	if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+		p.active = false
+		return false
+	}
+
+	mem := p.memory
+	if m := heapMetaspaceRE.FindStringSubmatch(line); len(m) >= 10 {
+		pool := MemoryPool{
+			Name:        m[1],
+			UsedKB:      sizeKB(m[2], m[3]),
+			CapacityKB:  sizeKB(m[4], m[5]),
+			CommittedKB: sizeKB(m[6], m[7]),
+			ReservedKB:  sizeKB(m[8], m[9]),
+		}
+		pool.UsedPercent = percentOf(pool.UsedKB, pool.CommittedKB)
+		if m[1] == "Metaspace" {
+			mem.MetaspaceUsedKB = pool.UsedKB
+			mem.MetaspaceCommittedKB = pool.CommittedKB
+		}
+		mem.Pools = append(mem.Pools, pool)
+		p.generation = ""
+	} else if m := heapZRE.FindStringSubmatch(line); len(m) >= 7 {
+		mem.Pools = append(mem.Pools, MemoryPool{
+			Name:       "ZHeap",
+			UsedKB:     sizeKB(m[1], m[2]),
+			CapacityKB: sizeKB(m[3], m[4]),
+			ReservedKB: sizeKB(m[5], m[6]),
+		})
+		p.addHeapTotals(sizeKB(m[3], m[4]), sizeKB(m[1], m[2]))
+	} else if m := heapShenandoahRE.FindStringSubmatch(line); len(m) >= 7 {
+		mem.Pools = append(mem.Pools, MemoryPool{
+			Name:        "Shenandoah Heap",
+			ReservedKB:  sizeKB(m[1], m[2]),
+			CommittedKB: sizeKB(m[3], m[4]),
+			CapacityKB:  sizeKB(m[3], m[4]),
+			UsedKB:      sizeKB(m[5], m[6]),
+		})
+		p.addHeapTotals(sizeKB(m[3], m[4]), sizeKB(m[5], m[6]))
+	} else if m := heapSpaceRE.FindStringSubmatch(line); len(m) >= 5 {
+		capacity := sizeKB(m[2], m[3])
+		percent, _ := strconv.ParseFloat(m[4], 64)
+		mem.Pools = append(mem.Pools, MemoryPool{
+			Name:        strings.Join(strings.Fields(m[1]), " "), // "to   space" is padded to align with "from space"
+			Parent:      p.generation,
+			CapacityKB:  capacity,
+			UsedKB:      int64(float64(capacity) * percent / 100),
+			UsedPercent: percent,
+		})
+	} else if m := heapGenerationRE.FindStringSubmatch(line); len(m) >= 6 {
+		capacity, used := sizeKB(m[2], m[3]), sizeKB(m[4], m[5])
+		mem.Pools = append(mem.Pools, MemoryPool{
+			Name:        m[1],
+			CapacityKB:  capacity,
+			UsedKB:      used,
+			UsedPercent: percentOf(used, capacity),
+		})
+		p.generation = m[1]
+		p.addHeapTotals(capacity, used)
+	}
+	// Other lines of the block (G1 region counts, address ranges) are skipped
+	return true
+}
+
+func (p *heapSectionParser) get() *JVMMemory {
+	if p.memory == nil {
+		p.memory = &JVMMemory{}
+	}
+	return p.memory
+}
+
+func (p *heapSectionParser) addHeapTotals(capacity, used int64) {
+	p.memory.HeapCapacityKB += capacity
+	p.memory.HeapUsedKB += used
+	p.memory.HeapUsedPercent = percentOf(p.memory.HeapUsedKB, p.memory.HeapCapacityKB)
+}
+
+// sizeKB converts a "262144K" style size into kilobytes, an empty number is 0
+func sizeKB(number, unit string) int64 {
+	val, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0
+	}
+	switch unit {
+	case "M":
+		return val * 1024
+	case "G":
+		return val * 1024 * 1024
+	default:
+		return val
+	}
+}
+
+func percentOf(used, capacity int64) float64 {
+	if capacity <= 0 {
+		return 0
+	}
+	return float64(used) / float64(capacity) * 100.0
+}
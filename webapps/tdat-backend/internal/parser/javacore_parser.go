@@ -0,0 +1,330 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	//Captures javacore section banners, e.g. "0SECTION       THREADS subcomponent dump routine"
+	j9SectionRE = regexp.MustCompile(`^0SECTION\s+(\S+)`)
+	//Captures thread name, J9VMThread address and state
+	j9ThreadInfoRE = regexp.MustCompile(`^3XMTHREADINFO\s+"(.*)"\s+J9VMThread:(0x[0-9a-fA-F]+).*?state:(\w+)`)
+	//Captures the Java priority from the thread header
+	j9PriorityRE = regexp.MustCompile(`prio=(\d+)`)
+	//Captures the Java thread id and daemon flag
+	j9JavaThreadRE = regexp.MustCompile(`^3XMJAVALTHREAD\s+\(java/lang/Thread getId:0[xX]([0-9a-fA-F]+), isDaemon:(true|false)\)`)
+	//Captures the native thread id in hex
+	j9NativeIDRE = regexp.MustCompile(`^3XMTHREADINFO1\s+\(native thread ID:0[xX]([0-9a-fA-F]+)`)
+	//Captures total CPU usage in seconds
+	j9CPUTimeRE = regexp.MustCompile(`^3XMCPUTIME\s+CPU usage total:\s+([\d\.]+) secs`)
+	//Captures what a thread is blocked, waiting or parked on, and the J9VMThread owning a parked-on synchronizer
+	j9ThreadBlockRE = regexp.MustCompile(`^3XMTHREADBLOCK\s+(Blocked on|Waiting on|Parked on):\s+(\S+)@(0x[0-9a-fA-F]+)(?:\s+Owned by:\s+".*?"\s+\(J9VMThread:(0x[0-9a-fA-F]+))?`)
+	//Captures a Java frame
+	j9StackFrameRE = regexp.MustCompile(`^4XESTACKTRACE\s+at\s+(.+)`)
+	//Captures a monitor entered by the frame above
+	j9EnteredLockRE = regexp.MustCompile(`^5XESTACKTRACE\s+\(entered lock:\s+(\S+)@(0x[0-9a-fA-F]+)`)
+	//Captures an in-use monitor and its owner from the LOCKS section, newer VMs add ", java/lang/Thread:0x..." after the J9VMThread
+	j9MonitorObjectRE = regexp.MustCompile(`^3LKMONOBJECT\s+(\S+)@(0x[0-9a-fA-F]+):\s*(?:Flat locked by|owner)\s+"(.*?)"\s+\(J9VMThread:(0x[0-9a-fA-F]+)(?:,\s*java/lang/Thread:0x[0-9a-fA-F]+)?\)`)
+	//Captures an unowned monitor from the LOCKS section
+	j9UnownedMonitorRE = regexp.MustCompile(`^3LKMONOBJECT\s+(\S+)@(0x[0-9a-fA-F]+):`)
+	//Captures the capture date, "1TIDATETIMEUTC" is preferred over the local "1TIDATETIME"
+	j9DateTimeRE = regexp.MustCompile(`^(1TIDATETIME(?:UTC)?)\s+Date:\s+(\d{4}/\d{2}/\d{2}) at (\d{2}:\d{2}:\d{2})(?::(\d{3}))?`)
+	//Captures the Java runtime version and build
+	j9JavaVersionRE = regexp.MustCompile(`^1CIJAVAVERSION\s+(.*?)(?:\(build ([^)]+)\))?\s*$`)
+	//Captures the VM name
+	j9VMVersionRE = regexp.MustCompile(`^1CIVMVERSION\s+(.+)$`)
+	//Captures the object heap size or the part of it in use, in bytes
+	j9HeapRE = regexp.MustCompile(`^1STHEAP(TOTAL|INUSE)\s+Total memory(?: in use)?:\s+(\d+)`)
+	//Captures a queued thread from the LOCKS section, optionally followed by ", java/lang/Thread:0x..."
+	j9MonitorWaiterRE = regexp.MustCompile(`^3LK(WAITER|WAITNOTIFY)\s+"(.*?)"\s+\(J9VMThread:(0x[0-9a-fA-F]+)(?:,\s*java/lang/Thread:0x[0-9a-fA-F]+)?\)`)
+)
+
+// j9States maps javacore thread states onto java.lang.Thread.State names
+var j9States = map[string]string{
+	"R":  "RUNNABLE",
+	"CW": "WAITING",
+	"P":  "WAITING",
+	"B":  "BLOCKED",
+	"S":  "SUSPENDED",
+	"Z":  "TERMINATED",
+}
+
+// j9Monitor is a monitor record from the LOCKS section
+type j9Monitor struct {
+	Address  string
+	Class    string
+	OwnerID  string // J9VMThread address
+	Entering []string
+	Notified []string
+}
+
+/* Parsing IBM J9 / OpenJ9 Javacores */
+
+func ParseJavacore(r io.Reader) (*ThreadDump, error) {
+	var threads []Thread
+	var metadata DumpMetadata
+	var currentThread *Thread
+	var pendingBlock string // 3XMTHREADBLOCK comes before the stack, held until the first frame
+	var monitors []*j9Monitor
+	var currentMonitor *j9Monitor
+	var waiterQueue string
+	synchronizerOwners := make(map[string][]LockAnnotation) // J9VMThread address -> parked-on synchronizers it owns
+	section := ""
+	threadDetails := false // Skips the "Current thread" block that repeats a thread
+
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, 1024*1024)
+
+	flushThread := func() {
+		if currentThread != nil {
+			threads = append(threads, *currentThread)
+		}
+		currentThread = nil
+		pendingBlock = ""
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if m := j9SectionRE.FindStringSubmatch(line); len(m) >= 2 {
+			flushThread()
+			section = m[1]
+			continue
+		}
+
+		switch section {
+		case "TITLE":
+			if m := j9DateTimeRE.FindStringSubmatch(line); len(m) >= 4 {
+				// Keep the UTC stamp once seen, the local one is only a fallback
+				if m[1] == "1TIDATETIMEUTC" || metadata.CaptureTime.IsZero() {
+					if ts, err := time.Parse("2006/01/02 15:04:05", m[2]+" "+m[3]); err == nil {
+						if ms, err := strconv.Atoi(m[4]); err == nil {
+							ts = ts.Add(time.Duration(ms) * time.Millisecond)
+						}
+						metadata.CaptureTime = ts
+					}
+				}
+			}
+
+		case "ENVINFO":
+			if m := j9JavaVersionRE.FindStringSubmatch(line); len(m) >= 3 {
+				metadata.VMVersion = strings.TrimSpace(m[2])
+				if metadata.VMVersion == "" {
+					metadata.VMVersion = strings.TrimSpace(m[1])
+				}
+				metadata.JavaMajorVersion = javaMajorVersion(strings.TrimPrefix(strings.TrimSpace(m[1]), "JRE "))
+			} else if m := j9VMVersionRE.FindStringSubmatch(line); len(m) >= 2 {
+				metadata.VMName = strings.TrimSpace(m[1])
+			}
+
+		case "MEMINFO":
+			if m := j9HeapRE.FindStringSubmatch(line); len(m) >= 3 {
+				if metadata.Memory == nil {
+					metadata.Memory = &JVMMemory{}
+				}
+				bytes, _ := strconv.ParseInt(m[2], 10, 64)
+				if m[1] == "TOTAL" {
+					metadata.Memory.HeapCapacityKB = bytes / 1024
+				} else {
+					metadata.Memory.HeapUsedKB = bytes / 1024
+				}
+				metadata.Memory.HeapUsedPercent = percentOf(metadata.Memory.HeapUsedKB, metadata.Memory.HeapCapacityKB)
+			}
+
+		case "LOCKS":
+			if m := j9MonitorObjectRE.FindStringSubmatch(line); len(m) >= 5 {
+				currentMonitor = &j9Monitor{Class: j9ClassName(m[1]), Address: m[2], OwnerID: m[4]}
+				monitors = append(monitors, currentMonitor)
+				continue
+			}
+			if m := j9UnownedMonitorRE.FindStringSubmatch(line); len(m) >= 3 {
+				currentMonitor = &j9Monitor{Class: j9ClassName(m[1]), Address: m[2]}
+				monitors = append(monitors, currentMonitor)
+				continue
+			}
+			if strings.HasPrefix(line, "3LKWAITERQ") || strings.HasPrefix(line, "3LKNOTIFYQ") {
+				waiterQueue = line[:10]
+				continue
+			}
+			if m := j9MonitorWaiterRE.FindStringSubmatch(line); len(m) >= 4 && currentMonitor != nil {
+				if waiterQueue == "3LKNOTIFYQ" {
+					currentMonitor.Notified = append(currentMonitor.Notified, m[3])
+				} else {
+					currentMonitor.Entering = append(currentMonitor.Entering, m[3])
+				}
+				continue
+			}
+			// Any other monitor group ends the current record
+			if strings.HasPrefix(line, "2LK") || strings.HasPrefix(line, "1LK") {
+				currentMonitor = nil
+			}
+
+		case "THREADS":
+			if strings.HasPrefix(line, "1XMTHDINFO") {
+				threadDetails = true
+				continue
+			}
+			if !threadDetails {
+				continue
+			}
+
+			// Check for thread header
+			if m := j9ThreadInfoRE.FindStringSubmatch(line); len(m) >= 4 {
+				flushThread()
+				state, known := j9States[m[3]]
+				if !known {
+					state = m[3]
+				}
+				currentThread = &Thread{
+					Name:       m[1],
+					ID:         m[2],
+					State:      state,
+					StackTrace: []string{},
+					Issues:     []string{},
+				}
+				if pm := j9PriorityRE.FindStringSubmatch(line); len(pm) >= 2 {
+					currentThread.Priority, _ = strconv.Atoi(pm[1])
+				}
+				continue
+			}
+			if currentThread == nil {
+				continue
+			}
+
+			// Extract Java thread id and daemon flag
+			if m := j9JavaThreadRE.FindStringSubmatch(line); len(m) >= 3 {
+				if val, err := strconv.ParseInt(m[1], 16, 64); err == nil {
+					currentThread.SequenceNumber = int(val)
+				}
+				currentThread.IsDaemon = m[2] == "true"
+				currentThread.HasDaemonFlag = true
+				continue
+			}
+
+			// Extract Native ID
+			if m := j9NativeIDRE.FindStringSubmatch(line); len(m) >= 2 {
+				if val, err := strconv.ParseInt(m[1], 16, 64); err == nil {
+					currentThread.NativeID = val
+				}
+				continue
+			}
+
+			// Extract CPU Time
+			if m := j9CPUTimeRE.FindStringSubmatch(line); len(m) >= 2 {
+				if val, err := strconv.ParseFloat(m[1], 64); err == nil {
+					currentThread.CPUTime = val * 1000
+				}
+				continue
+			}
+
+			// Blocked, waiting and parked objects become jstack style lock annotations
+			if m := j9ThreadBlockRE.FindStringSubmatch(line); len(m) >= 4 {
+				action := map[string]string{
+					"Blocked on": "waiting to lock",
+					"Waiting on": "waiting on",
+					"Parked on":  "parking to wait for",
+				}[m[1]]
+				pendingBlock = fmt.Sprintf("- %s <%s> (a %s)", action, m[3], j9ClassName(m[2]))
+				// Javacores have no synchronizer list, the owner of a parked-on lock is only named here
+				if m[1] == "Parked on" && m[4] != "" {
+					synchronizerOwners[m[4]] = append(synchronizerOwners[m[4]], LockAnnotation{Action: SynchronizerOwned, Address: m[3], Class: j9ClassName(m[2])})
+				}
+				continue
+			}
+
+			// Check for Stacktrace
+			if m := j9StackFrameRE.FindStringSubmatch(line); len(m) >= 2 {
+				currentThread.StackTrace = append(currentThread.StackTrace, "at "+j9FrameName(m[1]))
+				if pendingBlock != "" {
+					currentThread.StackTrace = append(currentThread.StackTrace, pendingBlock)
+					pendingBlock = ""
+				}
+				continue
+			}
+			if m := j9EnteredLockRE.FindStringSubmatch(line); len(m) >= 3 {
+				currentThread.StackTrace = append(currentThread.StackTrace, fmt.Sprintf("- locked <%s> (a %s)", m[2], j9ClassName(m[1])))
+			}
+		}
+	}
+	flushThread()
+
+	// Fold the LOCKS section into the same annotations the lock graph reads
+	applyJ9Monitors(threads, monitors)
+	applyJ9SynchronizerOwners(threads, synchronizerOwners)
+
+	return &ThreadDump{Format: FormatJavacore, Metadata: metadata, Threads: threads}, scanner.Err()
+}
+
+// applyJ9Monitors adds lock annotations from the LOCKS section that the thread stacks do not already show
+func applyJ9Monitors(threads []Thread, monitors []*j9Monitor) {
+	byID := make(map[string]*Thread)
+	for i := range threads {
+		byID[threads[i].ID] = &threads[i]
+	}
+
+	annotate := func(threadID, action string, m *j9Monitor) {
+		t, found := byID[threadID]
+		if !found {
+			return
+		}
+		for _, line := range t.StackTrace {
+			if strings.Contains(line, "<"+m.Address+">") {
+				return
+			}
+		}
+		lockLine := fmt.Sprintf("- %s <%s> (a %s)", action, m.Address, m.Class)
+		// Attach to the top frame, or stand alone when the thread has no Java stack
+		if len(t.StackTrace) == 0 {
+			t.StackTrace = append(t.StackTrace, lockLine)
+			return
+		}
+		t.StackTrace = append(t.StackTrace[:1], append([]string{lockLine}, t.StackTrace[1:]...)...)
+	}
+
+	for _, m := range monitors {
+		if m.OwnerID != "" {
+			annotate(m.OwnerID, "locked", m)
+		}
+		for _, waiter := range m.Entering {
+			annotate(waiter, "waiting to lock", m)
+		}
+		for _, waiter := range m.Notified {
+			annotate(waiter, "waiting on", m)
+		}
+	}
+}
+
+// applyJ9SynchronizerOwners records the j.u.c. locks other threads are parked on as owned by their holders
+func applyJ9SynchronizerOwners(threads []Thread, owners map[string][]LockAnnotation) {
+	for i := range threads {
+		t := &threads[i]
+		seen := make(map[string]bool)
+		for _, sync := range owners[t.ID] {
+			if seen[sync.Address] {
+				continue
+			}
+			seen[sync.Address] = true
+			t.OwnedSynchronizers = append(t.OwnedSynchronizers, sync)
+		}
+	}
+}
+
+// j9ClassName converts "java/lang/Object" to "java.lang.Object"
+func j9ClassName(name string) string {
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// j9FrameName converts the class part of a frame, leaving the source location untouched
+func j9FrameName(frame string) string {
+	if idx := strings.Index(frame, "("); idx >= 0 {
+		return j9ClassName(frame[:idx]) + frame[idx:]
+	}
+	return j9ClassName(frame)
+}
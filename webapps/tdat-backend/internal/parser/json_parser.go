@@ -0,0 +1,187 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* JSON Dump Structures (jcmd Thread.dump_to_file -format=json) */
+
+type jsonThreadDump struct {
+	ThreadDump struct {
+		Time             string                `json:"time"`
+		RuntimeVersion   string                `json:"runtimeVersion"`
+		ThreadContainers []jsonThreadContainer `json:"threadContainers"`
+	} `json:"threadDump"`
+}
+
+type jsonThreadContainer struct {
+	Container string       `json:"container"`
+	Parent    *string      `json:"parent"`
+	Owner     *jsonString  `json:"owner"`
+	Threads   []jsonThread `json:"threads"`
+}
+
+type jsonThread struct {
+	TID   jsonString `json:"tid"`
+	Name  string     `json:"name"`
+	Stack []string   `json:"stack"`
+
+	// Only written by newer JDKs
+	State         string           `json:"state"`
+	Virtual       *bool            `json:"virtual"`
+	Carrier       jsonString       `json:"carrier"`
+	BlockedOn     string           `json:"blockedOn"`
+	WaitingOn     string           `json:"waitingOn"`
+	MonitorsOwned []jsonOwnedLocks `json:"monitorsOwned"`
+}
+
+type jsonOwnedLocks struct {
+	Depth int      `json:"depth"`
+	Locks []string `json:"locks"`
+}
+
+// jsonString accepts both quoted and bare numeric values, JDK versions differ in how ids are written
+type jsonString string
+
+func (s *jsonString) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = jsonString(str)
+		return nil
+	}
+	var num json.Number
+	if err := json.Unmarshal(data, &num); err != nil {
+		return err
+	}
+	*s = jsonString(num.String())
+	return nil
+}
+
+/* Parsing JSON Thread Dumps */
+
+func ParseJSONThreadDump(r io.Reader) (*ThreadDump, error) {
+	var raw jsonThreadDump
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON thread dump: %w", err)
+	}
+
+	var threads []Thread
+	for _, container := range raw.ThreadDump.ThreadContainers {
+		for _, jt := range container.Threads {
+			t := Thread{
+				ID:         string(jt.TID),
+				Name:       jt.Name,
+				Container:  container.Container,
+				StackTrace: []string{},
+				Issues:     []string{},
+			}
+
+			// Frames carry no "at" prefix in JSON, add it so every format shares the text layout
+			for depth, frame := range jt.Stack {
+				t.StackTrace = append(t.StackTrace, "at "+strings.TrimSpace(frame))
+				t.StackTrace = append(t.StackTrace, jsonLockLines(jt, depth)...)
+			}
+
+			// The JSON tid is the Java thread id jstack prints as "#N"
+			if seq, err := strconv.Atoi(string(jt.TID)); err == nil {
+				t.SequenceNumber = seq
+			}
+
+			if jt.Virtual != nil {
+				t.IsVirtual = *jt.Virtual
+			} else {
+				t.IsVirtual = hasVirtualThreadFrame(t.StackTrace)
+			}
+			if t.IsVirtual {
+				t.CarrierID = string(jt.Carrier)
+			}
+
+			if jt.State != "" {
+				t.State = jt.State
+			} else {
+				t.State = inferStateFromStack(t.StackTrace)
+			}
+
+			threads = append(threads, t)
+		}
+	}
+
+	// Capture time and runtime version are part of the JSON document
+	metadata := DumpMetadata{
+		VMVersion:        raw.ThreadDump.RuntimeVersion,
+		JavaMajorVersion: javaMajorVersion(raw.ThreadDump.RuntimeVersion),
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, raw.ThreadDump.Time); err == nil {
+		metadata.CaptureTime = ts
+	}
+
+	return &ThreadDump{Format: FormatJSON, Metadata: metadata, Threads: threads}, nil
+}
+
+// jsonLockLines renders the lock details of a frame as jstack style annotations
+func jsonLockLines(jt jsonThread, depth int) []string {
+	var lines []string
+	if depth == 0 {
+		if line, ok := jsonLockLine("waiting to lock", jt.BlockedOn); ok {
+			lines = append(lines, line)
+		}
+		if line, ok := jsonLockLine("waiting on", jt.WaitingOn); ok {
+			lines = append(lines, line)
+		}
+	}
+	for _, owned := range jt.MonitorsOwned {
+		if owned.Depth != depth {
+			continue
+		}
+		for _, lock := range owned.Locks {
+			if line, ok := jsonLockLine("locked", lock); ok {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// jsonLockLine turns "java.lang.Object@1b6d3586" into "- locked <0x1b6d3586> (a java.lang.Object)"
+func jsonLockLine(action, lock string) (string, bool) {
+	at := strings.LastIndex(lock, "@")
+	if at <= 0 || at == len(lock)-1 {
+		return "", false
+	}
+	return fmt.Sprintf("- %s <0x%s> (a %s)", action, lock[at+1:], lock[:at]), true
+}
+
+// hasVirtualThreadFrame reports whether a stack runs inside a virtual thread continuation
+func hasVirtualThreadFrame(stack []string) bool {
+	for _, line := range stack {
+		if strings.Contains(line, "java.lang.VirtualThread.run(") {
+			return true
+		}
+	}
+	return false
+}
+
+// inferStateFromStack derives a java.lang.Thread.State from the top frame when the dump omits it
+func inferStateFromStack(stack []string) string {
+	if len(stack) == 0 {
+		return ""
+	}
+	top := stack[0]
+	switch {
+	case strings.Contains(top, "java.lang.Thread.sleep"),
+		strings.Contains(top, "java.lang.VirtualThread.parkNanos"):
+		return "TIMED_WAITING"
+	case strings.Contains(top, "jdk.internal.misc.Unsafe.park"),
+		strings.Contains(top, "java.lang.VirtualThread.park"),
+		strings.Contains(top, "java.lang.Object.wait"),
+		strings.Contains(top, "jdk.internal.vm.Continuation.yield"):
+		return "WAITING"
+	default:
+		return "RUNNABLE"
+	}
+}
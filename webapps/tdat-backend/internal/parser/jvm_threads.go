@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"regexp"
+)
+
+const (
+	ThreadKindJava        = "java"
+	ThreadKindJVMInternal = "jvm-internal"
+	ThreadKindGoroutine   = "goroutine"
+)
+
+const (
+	InternalCategoryGC       = "gc"
+	InternalCategoryCompiler = "compiler"
+	InternalCategoryVM       = "vm"
+	InternalCategoryOther    = "other"
+)
+
+// internalThreadPattern maps thread names the JVM gives its own threads onto a category
+type internalThreadPattern struct {
+	Category string
+	Name     *regexp.Regexp
+}
+
+// Checked in order, HotSpot names first and OpenJ9 names after them
+var internalThreadPatterns = []internalThreadPattern{
+	{InternalCategoryGC, regexp.MustCompile(`^(GC Thread#\d+|G1 |GC task thread#|Gang worker#|Parallel GC Threads|Concurrent Mark-Sweep GC Thread|Surrogate Locker Thread|ZDriver|ZDirector|ZStat|ZUnmapper|ZWorker|ZRefProcessor|Shenandoah|GC Slave|GC Worker|Concurrent Mark Helper|Finalizer master)`)},
+	{InternalCategoryCompiler, regexp.MustCompile(`^(C1 CompilerThread\d+|C2 CompilerThread\d+|JVMCI CompilerThread\d+|JVMCI-native CompilerThread\d+|Sweeper thread|JIT Compilation Thread-\d+|JIT Diagnostic Compilation Thread-\d+|JIT-SamplerThread|JIT IProfiler)`)},
+	{InternalCategoryVM, regexp.MustCompile(`^(VM Thread|VM Periodic Task Thread|VM JFR Buffer Thread|Monitor Deflation Thread|Service Thread|Notification Thread)$`)},
+}
+
+// classifyThread sets the kind of a thread. Threads the JVM runs for itself are recognized by name,
+// readers may already have marked unnamed ones as internal from the entry layout.
+func classifyThread(t *Thread) {
+	if t.Kind == ThreadKindGoroutine {
+		return
+	}
+	if !t.IsVirtual {
+		for _, p := range internalThreadPatterns {
+			if p.Name.MatchString(t.Name) {
+				t.Kind = ThreadKindJVMInternal
+				t.InternalCategory = p.Category
+				return
+			}
+		}
+	}
+	if t.Kind == ThreadKindJVMInternal {
+		t.InternalCategory = InternalCategoryOther
+		return
+	}
+	t.Kind = ThreadKindJava
+}
+
+// nativeStates maps the state text at the end of a HotSpot header onto java.lang.Thread.State names.
+// Internal threads have no "java.lang.Thread.State" line, for Java threads that line overrides it.
+var nativeStates = map[string]string{
+	"runnable":                  "RUNNABLE",
+	"waiting on condition":      "WAITING",
+	"in Object.wait()":          "WAITING",
+	"waiting for monitor entry": "BLOCKED",
+	"sleeping":                  "TIMED_WAITING",
+}
@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LockWaiter is a thread that is blocked, parked or waiting on a monitor
+type LockWaiter struct {
+	ThreadID   string `json:"thread_id"`
+	ThreadName string `json:"thread_name"`
+	WaitType   string `json:"wait_type"` // "blocked", "parked", "waiting"
+}
+
+// Monitor is a lock object together with its owner and the threads queued on it
+type Monitor struct {
+	Address   string       `json:"address"`
+	Class     string       `json:"class"`
+	OwnerID   string       `json:"owner_id,omitempty"`
+	OwnerName string       `json:"owner_name,omitempty"`
+	Waiters   []LockWaiter `json:"waiters,omitempty"`
+}
+
+// LockCycle is a wait-for cycle: ThreadNames[i] wants Locks[i], which is held by ThreadNames[i+1]
+type LockCycle struct {
+	ThreadIDs   []string `json:"thread_ids"`
+	ThreadNames []string `json:"thread_names"`
+	Locks       []string `json:"locks"`
+}
+
+// LockGraph is the lock ownership and wait-for model of a single dump
+type LockGraph struct {
+	Monitors []Monitor   `json:"monitors"`
+	Cycles   []LockCycle `json:"cycles,omitempty"`
+}
+
+const (
+	WaitTypeBlocked = "blocked"
+	WaitTypeParked  = "parked"
+	WaitTypeWaiting = "waiting"
+)
+
+var (
+	//Captures lock annotations: action, object address and class
+	lockLineRE = regexp.MustCompile(`^-\s+(locked|waiting to lock|waiting to re-lock in wait\(\)|waiting on|parking to wait for|eliminated)\s+<(0x[0-9a-fA-F]+)>\s+\(a ([^)]+)\)`)
+)
+
+/* Building the Lock Graph */
+
+// BuildLockGraph links lock annotations of all threads into monitors and finds wait-for cycles.
+// Threads found in a cycle are flagged as deadlocked.
+func BuildLockGraph(threads []Thread) *LockGraph {
+	monitors := make(map[string]*Monitor)
+	var order []string
+	owners := make(map[string]int) // lock address -> index of the owning thread
+	wantedLock := make(map[int]string)
+
+	getMonitor := func(address, class string) *Monitor {
+		m, exists := monitors[address]
+		if !exists {
+			m = &Monitor{Address: address, Class: class}
+			monitors[address] = m
+			order = append(order, address)
+		}
+		return m
+	}
+
+	// Ownable synchronizers (jstack -l) give the owners of j.u.c. locks that threads park on
+	for i := range threads {
+		t := &threads[i]
+		for _, sync := range t.OwnedSynchronizers {
+			monitor := getMonitor(sync.Address, sync.Class)
+			if _, owned := owners[sync.Address]; owned {
+				continue
+			}
+			monitor.OwnerID = t.ID
+			monitor.OwnerName = t.Name
+			owners[sync.Address] = i
+		}
+	}
+
+	for i := range threads {
+		t := &threads[i]
+
+		// Object.wait() releases the monitor even though a "- locked" line for it is still printed
+		released := make(map[string]bool)
+		for _, line := range t.StackTrace {
+			if m := lockLineRE.FindStringSubmatch(line); len(m) >= 4 && m[1] == "waiting on" {
+				released[m[2]] = true
+			}
+		}
+
+		for _, line := range t.StackTrace {
+			m := lockLineRE.FindStringSubmatch(line)
+			if len(m) < 4 {
+				continue
+			}
+			action, address, class := m[1], m[2], strings.TrimSpace(m[3])
+			monitor := getMonitor(address, class)
+
+			switch action {
+			case "locked":
+				if _, owned := owners[address]; owned || released[address] {
+					continue
+				}
+				monitor.OwnerID = t.ID
+				monitor.OwnerName = t.Name
+				owners[address] = i
+			case "waiting to lock", "waiting to re-lock in wait()":
+				monitor.Waiters = append(monitor.Waiters, LockWaiter{ThreadID: t.ID, ThreadName: t.Name, WaitType: WaitTypeBlocked})
+				if _, exists := wantedLock[i]; !exists {
+					wantedLock[i] = address
+				}
+			case "parking to wait for":
+				monitor.Waiters = append(monitor.Waiters, LockWaiter{ThreadID: t.ID, ThreadName: t.Name, WaitType: WaitTypeParked})
+				if _, exists := wantedLock[i]; !exists {
+					wantedLock[i] = address
+				}
+			case "waiting on":
+				monitor.Waiters = append(monitor.Waiters, LockWaiter{ThreadID: t.ID, ThreadName: t.Name, WaitType: WaitTypeWaiting})
+			}
+		}
+	}
+
+	graph := &LockGraph{Monitors: []Monitor{}}
+	for _, address := range order {
+		graph.Monitors = append(graph.Monitors, *monitors[address])
+	}
+	// Most contended monitors first
+	sort.SliceStable(graph.Monitors, func(a, b int) bool {
+		return len(graph.Monitors[a].Waiters) > len(graph.Monitors[b].Waiters)
+	})
+
+	graph.Cycles = findLockCycles(threads, wantedLock, owners)
+
+	return graph
+}
+
+// findLockCycles walks the wait-for edges (thread -> owner of the lock it wants) and returns every cycle once.
+// Threads in a cycle are flagged as deadlocked.
+func findLockCycles(threads []Thread, wantedLock map[int]string, owners map[string]int) []LockCycle {
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	color := make([]int, len(threads))
+	var cycles []LockCycle
+
+	for start := range threads {
+		if color[start] != unvisited {
+			continue
+		}
+		// Each thread waits on at most one lock, so the walk is a simple path
+		var path []int
+		current := start
+		for {
+			if color[current] == inProgress {
+				// Cycle found, it starts where current first appears on the path
+				cycleStart := 0
+				for path[cycleStart] != current {
+					cycleStart++
+				}
+				for _, idx := range path[cycleStart:] {
+					threads[idx].IsDeadlocked = true
+				}
+				cycles = append(cycles, newLockCycle(threads, path[cycleStart:], wantedLock))
+				break
+			}
+			if color[current] == done {
+				break
+			}
+			color[current] = inProgress
+			path = append(path, current)
+
+			lock, waiting := wantedLock[current]
+			if !waiting {
+				break
+			}
+			owner, owned := owners[lock]
+			if !owned || owner == current {
+				break
+			}
+			current = owner
+		}
+		for _, idx := range path {
+			color[idx] = done
+		}
+	}
+	return cycles
+}
+
+func newLockCycle(threads []Thread, members []int, wantedLock map[int]string) LockCycle {
+	cycle := LockCycle{}
+	for _, idx := range members {
+		cycle.ThreadIDs = append(cycle.ThreadIDs, threads[idx].ID)
+		cycle.ThreadNames = append(cycle.ThreadNames, threads[idx].Name)
+		cycle.Locks = append(cycle.Locks, wantedLock[idx])
+	}
+	return cycle
+}
@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DumpMetadata describes when and from which JVM a dump was captured
+type DumpMetadata struct {
+	CaptureTime      time.Time `json:"capture_time,omitzero"`
+	VMName           string    `json:"vm_name,omitempty"`
+	VMVersion        string    `json:"vm_version,omitempty"`
+	VMMode           string    `json:"vm_mode,omitempty"`
+	JavaMajorVersion int       `json:"java_major_version,omitempty"`
+	// JNI references and heap summary, only present in dumps written by the JVM itself (kill -3, javacore)
+	Memory *JVMMemory `json:"memory,omitempty"`
+}
+
+var (
+	//Captures VM name, version and mode from "Full thread dump <VM name> (<version> <mode>):"
+	dumpBannerVMRE = regexp.MustCompile(`Full thread dump (.+) \(([^\s()]+) ([^()]*)\):?\s*$`)
+	//Captures the leading version number
+	versionNumberRE = regexp.MustCompile(`^(\d+)(?:\.(\d+))?`)
+	//Matches the HotSpot build, e.g. "25.281-b09", that JDK 8 and earlier print instead of the Java version
+	hotspotBuildRE = regexp.MustCompile(`^\d+\.\d+-b\d+$`)
+	//Captures the Java version from "java -version" ('openjdk version "1.8.0_362"', "Runtime Environment (build 1.8.0_362-b09)")
+	//or "jcmd VM.version" ("JDK 8.0_362") output collected along with the dump
+	javaVersionLineRE = regexp.MustCompile(`^\s*(?:(?:java|openjdk) version "([^"]+)"|.*Runtime Environment.*\(build ([^)]+)\)|JDK (\d[\w.+\-]*))\s*$`)
+)
+
+// hotspotTimestampLayout is the capture timestamp printed above HotSpot dumps, it carries no zone
+const hotspotTimestampLayout = "2006-01-02 15:04:05"
+
+/* Parsing Dump Metadata */
+
+// parseHotSpotTimestamp reads the line above the banner, it is taken as UTC since no zone is given
+func parseHotSpotTimestamp(raw string) time.Time {
+	raw = strings.Replace(strings.TrimSpace(raw), "T", " ", 1)
+	ts, err := time.Parse(hotspotTimestampLayout, raw)
+	if err != nil {
+		return time.Time{}
+	}
+	return ts
+}
+
+// parseJavaVersionLine returns the Java version of a version line found next to the dump
+func parseJavaVersionLine(line string) (string, bool) {
+	m := javaVersionLineRE.FindStringSubmatch(line)
+	if len(m) < 4 {
+		return "", false
+	}
+	return m[1] + m[2] + m[3], true
+}
+
+// parseHotSpotBanner fills the VM fields from the "Full thread dump" line. Banners of JDK 8 and earlier
+// only carry the HotSpot build, the Java release is then taken from a version line of the file, if any.
+func parseHotSpotBanner(line, javaVersion string, meta *DumpMetadata) {
+	m := dumpBannerVMRE.FindStringSubmatch(line)
+	if len(m) < 4 {
+		return
+	}
+	meta.VMName = strings.TrimSpace(m[1])
+	meta.VMVersion = m[2]
+	meta.VMMode = strings.TrimSpace(m[3])
+	// The HotSpot build number does not tell the Java release
+	if hotspotBuildRE.MatchString(meta.VMVersion) {
+		meta.JavaMajorVersion = javaMajorVersion(javaVersion)
+	} else {
+		meta.JavaMajorVersion = javaMajorVersion(meta.VMVersion)
+	}
+}
+
+// javaMajorVersion reads the feature release from a Java version string, "1.8.0_281" is 8 and "21.0.2+13-58" is 21
+func javaMajorVersion(version string) int {
+	m := versionNumberRE.FindStringSubmatch(strings.TrimSpace(version))
+	if len(m) < 2 {
+		return 0
+	}
+	major, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
+	}
+	if major == 1 {
+		// Legacy "1.8.0_281" scheme
+		minor, _ := strconv.Atoi(m[2])
+		return minor
+	}
+	return major
+}
@@ -0,0 +1,190 @@
+package parser
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UsageFormatProcStat is the concatenated content of /proc/<pid>/task/*/stat
+const UsageFormatProcStat = "proc-stat"
+
+// ClockTicksPerSecond is USER_HZ, the unit of utime, stime and starttime. It is 100 on all common Linux builds.
+const ClockTicksPerSecond = 100.0
+
+// ErrProcStatInterval is returned when the time between captures cannot be told
+var ErrProcStatInterval = errors.New("/proc task stat captures need timestamp lines or /proc/uptime between them")
+
+var (
+	//Captures a task stat line: tid, comm in parentheses (may itself hold spaces and parentheses) and the remaining fields
+	procStatLineRE = regexp.MustCompile(`^(\d+) \((.*)\) ([A-Za-z]) (.+)$`)
+	//Captures /proc/uptime: seconds since boot and idle seconds
+	procUptimeRE = regexp.MustCompile(`^(\d+\.\d+) \d+\.\d+$`)
+	//Captures epoch seconds from "date +%s" or "date +%s.%N"
+	epochLineRE = regexp.MustCompile(`^(\d{10})(\.\d+)?$`)
+)
+
+// Layouts of timestamp lines written between captures
+var captureTimeLayouts = []string{
+	time.UnixDate, // date
+	"Mon Jan _2 15:04:05 2006",
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// Positions in the fields following the state, counted from field 4 of stat
+const (
+	procUtimeField     = 14 - 4
+	procStimeField     = 15 - 4
+	procStarttimeField = 22 - 4
+)
+
+// procCapture is one round of stat lines
+type procCapture struct {
+	at      time.Time
+	uptime  float64 // Seconds since boot, 0 when not captured
+	ticks   map[int64]float64
+	started map[int64]float64 // starttime in ticks since boot
+	order   []int64
+}
+
+/* Parsing /proc Task Stat */
+
+// isProcTaskStat reports whether the lines are /proc task stat records rather than tool output
+func isProcTaskStat(lines []string) bool {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || isCaptureMarker(line) {
+			continue
+		}
+		return procStatLineRE.MatchString(line)
+	}
+	return false
+}
+
+// parseProcTaskStat turns one or more captures of /proc/<pid>/task/*/stat into usages. With two or
+// more captures the CPU percentage is the delta between the first and the last one, a single capture
+// with /proc/uptime gives the average over each thread's lifetime. A single capture without it still
+// yields the CPU time of each thread, with no percentage, for correlation and CPU deltas across dumps.
+func parseProcTaskStat(lines []string) ([]ThreadUsage, error) {
+	var captures []*procCapture
+	current := &procCapture{ticks: make(map[int64]float64), started: make(map[int64]float64)}
+	startCapture := func() {
+		if len(current.order) > 0 {
+			captures = append(captures, current)
+			current = &procCapture{ticks: make(map[int64]float64), started: make(map[int64]float64)}
+		}
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if m := procUptimeRE.FindStringSubmatch(line); len(m) >= 2 {
+			startCapture()
+			current.uptime, _ = strconv.ParseFloat(m[1], 64)
+			continue
+		}
+		if ts, ok := parseCaptureTime(line); ok {
+			startCapture()
+			current.at = ts
+			continue
+		}
+		m := procStatLineRE.FindStringSubmatch(line)
+		if len(m) < 5 {
+			continue
+		}
+		tid, _ := strconv.ParseInt(m[1], 10, 64)
+		fields := strings.Fields(m[4])
+		if len(fields) <= procStarttimeField {
+			continue
+		}
+		// A thread seen again starts the next capture when no timestamp line separates them
+		if _, seen := current.ticks[tid]; seen {
+			startCapture()
+		}
+		utime, _ := strconv.ParseFloat(fields[procUtimeField], 64)
+		stime, _ := strconv.ParseFloat(fields[procStimeField], 64)
+		starttime, _ := strconv.ParseFloat(fields[procStarttimeField], 64)
+		current.ticks[tid] = utime + stime
+		current.started[tid] = starttime
+		current.order = append(current.order, tid)
+	}
+	startCapture()
+
+	if len(captures) == 0 {
+		return nil, ErrUnknownUsageFormat
+	}
+	last := captures[len(captures)-1]
+
+	var usages []ThreadUsage
+	if len(captures) >= 2 {
+		first := captures[0]
+		interval := captureInterval(first, last)
+		if interval <= 0 {
+			return nil, ErrProcStatInterval
+		}
+		for _, tid := range last.order {
+			// Threads started after the first capture count from zero
+			delta := last.ticks[tid] - first.ticks[tid]
+			usages = append(usages, ThreadUsage{
+				TID:           tid,
+				CPUPercentage: delta / ClockTicksPerSecond / interval * 100.0,
+				HasCPU:        true,
+				UserTime:      last.ticks[tid] / ClockTicksPerSecond * 1000,
+			})
+		}
+		return usages, nil
+	}
+
+	for _, tid := range last.order {
+		usage := ThreadUsage{TID: tid, UserTime: last.ticks[tid] / ClockTicksPerSecond * 1000}
+		if last.uptime <= 0 {
+			usages = append(usages, usage)
+			continue
+		}
+		if lifetime := last.uptime - last.started[tid]/ClockTicksPerSecond; lifetime > 0 {
+			usage.CPUPercentage = last.ticks[tid] / ClockTicksPerSecond / lifetime * 100.0
+			usage.HasCPU = true
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// captureInterval prefers /proc/uptime, which is immune to clock changes, over the timestamp lines
+func captureInterval(first, last *procCapture) float64 {
+	if first.uptime > 0 && last.uptime > 0 {
+		return last.uptime - first.uptime
+	}
+	if !first.at.IsZero() && !last.at.IsZero() {
+		return last.at.Sub(first.at).Seconds()
+	}
+	return 0
+}
+
+// isCaptureMarker reports lines written between captures
+func isCaptureMarker(line string) bool {
+	if procUptimeRE.MatchString(line) {
+		return true
+	}
+	_, ok := parseCaptureTime(line)
+	return ok
+}
+
+func parseCaptureTime(line string) (time.Time, bool) {
+	if m := epochLineRE.FindStringSubmatch(line); len(m) >= 2 {
+		secs, _ := strconv.ParseFloat(line, 64)
+		return time.Unix(0, int64(secs*float64(time.Second))).UTC(), true
+	}
+	for _, layout := range captureTimeLayouts {
+		if ts, err := time.Parse(layout, line); err == nil {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// statLine renders a /proc/<pid>/task/<tid>/stat line with the given utime, stime and starttime in ticks
+func statLine(tid int64, comm string, utime, stime, starttime int) string {
+	return fmt.Sprintf("%d (%s) S 1 100 100 0 -1 4194560 10 0 0 0 %d %d 0 0 20 0 30 0 %d 1000 100", tid, comm, utime, stime, starttime)
+}
+
+func TestParseProcTaskStat(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []ThreadUsage
+	}{
+		{
+			name: "two captures with timestamps",
+			lines: []string{
+				"1714564800",
+				statLine(101, "java", 100, 50, 1000),
+				statLine(102, "GC Thread#0", 10, 0, 1000),
+				"1714564810",
+				statLine(101, "java", 800, 250, 1000),
+				statLine(102, "GC Thread#0", 10, 0, 1000),
+				statLine(103, "new worker", 20, 30, 2000),
+			},
+			want: []ThreadUsage{
+				{TID: 101, CPUPercentage: 90, HasCPU: true, UserTime: 10500},
+				{TID: 102, CPUPercentage: 0, HasCPU: true, UserTime: 100},
+				// Started after the first capture, counts from zero
+				{TID: 103, CPUPercentage: 5, HasCPU: true, UserTime: 500},
+			},
+		},
+		{
+			name: "two captures with uptime and no separators between thread lists",
+			lines: []string{
+				"2000.00 1500.00",
+				statLine(101, "java (main)", 0, 0, 1000),
+				statLine(101, "java (main)", 150, 50, 1000),
+			},
+			want: nil, // Without a second marker the repeated thread starts a capture with no interval
+		},
+		{
+			name: "two captures with uptime",
+			lines: []string{
+				"2000.00 1500.00",
+				statLine(101, "java (main)", 0, 0, 1000),
+				"2004.00 1500.00",
+				statLine(101, "java (main)", 150, 50, 1000),
+			},
+			want: []ThreadUsage{{TID: 101, CPUPercentage: 50, HasCPU: true, UserTime: 2000}},
+		},
+		{
+			name: "single capture with uptime",
+			lines: []string{
+				"2000.00 1500.00",
+				statLine(101, "java", 1500, 500, 100000), // Started at 1000s, 20s of CPU in 1000s
+				statLine(102, "idle", 0, 0, 150000),
+			},
+			want: []ThreadUsage{
+				{TID: 101, CPUPercentage: 2, HasCPU: true, UserTime: 20000},
+				{TID: 102, CPUPercentage: 0, HasCPU: true, UserTime: 0},
+			},
+		},
+		{
+			name: "single capture without uptime",
+			lines: []string{
+				statLine(101, "java", 1500, 500, 100000),
+				statLine(102, "worker", 30, 20, 100000),
+			},
+			want: []ThreadUsage{
+				{TID: 101, UserTime: 20000},
+				{TID: 102, UserTime: 500},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !isProcTaskStat(tt.lines) {
+				t.Fatal("lines not recognised as /proc task stat")
+			}
+			got, err := parseProcTaskStat(tt.lines)
+			if tt.want == nil {
+				if !errors.Is(err, ErrProcStatInterval) {
+					t.Fatalf("err = %v, want %v", err, ErrProcStatInterval)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d usages %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				g := got[i]
+				if g.TID != want.TID || g.HasCPU != want.HasCPU ||
+					math.Abs(g.CPUPercentage-want.CPUPercentage) > 1e-9 || math.Abs(g.UserTime-want.UserTime) > 1e-9 {
+					t.Errorf("usage %d = %+v, want %+v", i, g, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseProcTaskStatErrors(t *testing.T) {
+	// Identical timestamps leave no interval between the captures
+	sameTime := []string{
+		"2024-05-01 10:00:00",
+		statLine(101, "java", 0, 0, 1000),
+		"2024-05-01 10:00:00",
+		statLine(101, "java", 100, 0, 1000),
+	}
+	if _, err := parseProcTaskStat(sameTime); !errors.Is(err, ErrProcStatInterval) {
+		t.Errorf("err = %v, want %v", err, ErrProcStatInterval)
+	}
+
+	// Markers alone hold no thread
+	if _, err := parseProcTaskStat([]string{"1714564800", "1714564810"}); !errors.Is(err, ErrUnknownUsageFormat) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownUsageFormat)
+	}
+
+	if isProcTaskStat(strings.Split("  PID USER      PR  NI    VIRT    RES    SHR S  %CPU  %MEM     TIME+ COMMAND", "\n")) {
+		t.Error("top output recognised as /proc task stat")
+	}
+}
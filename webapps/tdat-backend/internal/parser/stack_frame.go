@@ -0,0 +1,154 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LockAnnotation is a "- locked", "- waiting to lock", ... line attached to a frame,
+// or an entry of a thread's "Locked ownable synchronizers" block
+type LockAnnotation struct {
+	Action  string `json:"action"` // "locked", "waiting to lock", "waiting on", "parking to wait for", "eliminated", "owns"
+	Address string `json:"address"`
+	Class   string `json:"class"`
+}
+
+// StackFrame is one parsed "at ..." line of a stack trace, or a function of a goroutine trace
+// where Package is the import path and Class the receiver of a method
+type StackFrame struct {
+	ClassLoader   string           `json:"class_loader,omitempty"`
+	Module        string           `json:"module,omitempty"` // Module name and version, e.g. "java.base@17.0.2"
+	Package       string           `json:"package,omitempty"`
+	Class         string           `json:"class"`
+	Method        string           `json:"method"`
+	File          string           `json:"file,omitempty"`
+	Line          int              `json:"line,omitempty"`
+	IsNative      bool             `json:"is_native,omitempty"`
+	UnknownSource bool             `json:"unknown_source,omitempty"`
+	Locks         []LockAnnotation `json:"locks,omitempty"`
+}
+
+// SynchronizerOwned is the action of ownable synchronizer entries
+const SynchronizerOwned = "owns"
+
+var (
+	//Captures the qualified method and the source location of a frame
+	frameRE = regexp.MustCompile(`^at\s+(\S+?)\((.*)\)\s*$`)
+	//Captures file and line, J9 may append "(Compiled Code)" after the line number
+	frameLocationRE = regexp.MustCompile(`^(.+?):(\d+)`)
+)
+
+// QualifiedName returns "package.Class.method", or "package.function" for Go functions
+func (f StackFrame) QualifiedName() string {
+	if f.Class == "" {
+		return f.Package + "." + f.Method
+	}
+	if f.Package == "" {
+		return f.Class + "." + f.Method
+	}
+	return f.Package + "." + f.Class + "." + f.Method
+}
+
+// QualifiedClass returns "package.Class", or the package of Go functions
+func (f StackFrame) QualifiedClass() string {
+	if f.Package == "" || f.Class == "" {
+		return f.Package + f.Class
+	}
+	return f.Package + "." + f.Class
+}
+
+/* Parsing Stack Frames */
+
+// ParseFrames turns raw stack lines into structured frames, lock lines attach to the frame above them.
+func ParseFrames(lines []string) []StackFrame {
+	frames := []StackFrame{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		if m := lockLineRE.FindStringSubmatch(line); len(m) >= 4 {
+			if len(frames) > 0 {
+				last := &frames[len(frames)-1]
+				last.Locks = append(last.Locks, LockAnnotation{Action: m[1], Address: m[2], Class: strings.TrimSpace(m[3])})
+			}
+			continue
+		}
+
+		if frame, ok := parseFrame(line); ok {
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+// parseFrame reads "at [loader/][module@version/]package.Class.method([module@version/]File.java:12)",
+// hidden classes keep their "/0x..." suffix: "at pkg.Foo$$Lambda$123/0x0000000800c0b440.run(Unknown Source)"
+func parseFrame(line string) (StackFrame, bool) {
+	m := frameRE.FindStringSubmatch(line)
+	if len(m) < 3 {
+		return StackFrame{}, false
+	}
+	var frame StackFrame
+
+	// "loader//..." has a loader but no module, otherwise up to two prefixes: "loader/module/..." or "module/...",
+	// where the module may carry a version ("java.base@17.0.2") or not ("java.base" in JSON dumps)
+	qualified := m[1]
+	maxPrefixes := 2
+	if loader, rest, ok := strings.Cut(qualified, "//"); ok {
+		frame.ClassLoader, qualified = loader, rest
+		maxPrefixes = 1
+	}
+	var prefixes []string
+	for len(prefixes) < maxPrefixes {
+		prefix, rest, ok := strings.Cut(qualified, "/")
+		// A "/0x..." segment is the suffix of a hidden class, not the end of a prefix
+		if !ok || strings.HasPrefix(rest, "0x") || !strings.Contains(rest, ".") {
+			break
+		}
+		prefixes = append(prefixes, prefix)
+		qualified = rest
+	}
+	switch len(prefixes) {
+	case 2:
+		frame.ClassLoader, frame.Module = prefixes[0], prefixes[1]
+	case 1:
+		frame.Module = prefixes[0]
+	}
+
+	// Split "package.Class.method"
+	dot := strings.LastIndex(qualified, ".")
+	if dot < 0 {
+		frame.Method = qualified
+	} else {
+		frame.Method = qualified[dot+1:]
+		class := qualified[:dot]
+		if classDot := strings.LastIndex(class, "."); classDot >= 0 {
+			frame.Package, frame.Class = class[:classDot], class[classDot+1:]
+		} else {
+			frame.Class = class
+		}
+	}
+
+	// Source location, jstack prints the module inside the parentheses: "(java.base@17.0.2/Thread.java:833)"
+	location := strings.TrimSpace(m[2])
+	if slash := strings.LastIndex(location, "/"); slash >= 0 {
+		if frame.Module == "" {
+			frame.Module = location[:slash]
+		}
+		location = location[slash+1:]
+	}
+	switch {
+	case location == "Native Method":
+		frame.IsNative = true
+	case location == "Unknown Source" || location == "":
+		frame.UnknownSource = true
+	default:
+		if lm := frameLocationRE.FindStringSubmatch(location); len(lm) >= 3 {
+			frame.File = lm[1]
+			frame.Line, _ = strconv.Atoi(lm[2])
+		} else {
+			frame.File = location
+		}
+	}
+	return frame, true
+}
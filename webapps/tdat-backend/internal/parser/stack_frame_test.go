@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFrame(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want StackFrame
+	}{
+		{
+			name: "plain",
+			line: "at com.example.Worker.process(Worker.java:42)",
+			want: StackFrame{Package: "com.example", Class: "Worker", Method: "process", File: "Worker.java", Line: 42},
+		},
+		{
+			name: "module in location",
+			line: "at java.lang.Thread.run(java.base@17.0.2/Thread.java:833)",
+			want: StackFrame{Module: "java.base@17.0.2", Package: "java.lang", Class: "Thread", Method: "run", File: "Thread.java", Line: 833},
+		},
+		{
+			name: "module@version prefix",
+			line: "at java.base@17.0.2/java.lang.Thread.run(Thread.java:833)",
+			want: StackFrame{Module: "java.base@17.0.2", Package: "java.lang", Class: "Thread", Method: "run", File: "Thread.java", Line: 833},
+		},
+		{
+			name: "loader and module prefix",
+			line: "at app/com.example.core@1.2/com.example.Worker.process(Worker.java:42)",
+			want: StackFrame{ClassLoader: "app", Module: "com.example.core@1.2", Package: "com.example", Class: "Worker", Method: "process", File: "Worker.java", Line: 42},
+		},
+		{
+			name: "module without version",
+			line: "at java.base/java.lang.Thread.run(Thread.java:1583)",
+			want: StackFrame{Module: "java.base", Package: "java.lang", Class: "Thread", Method: "run", File: "Thread.java", Line: 1583},
+		},
+		{
+			name: "loader and module without version",
+			line: "at loader/java.base/java.lang.Thread.run(Thread.java:1583)",
+			want: StackFrame{ClassLoader: "loader", Module: "java.base", Package: "java.lang", Class: "Thread", Method: "run", File: "Thread.java", Line: 1583},
+		},
+		{
+			name: "hidden class with unversioned module",
+			line: "at java.base/java.lang.invoke.LambdaForm$MH/0x0000000800c0c400.invoke(LambdaForm$MH)",
+			want: StackFrame{Module: "java.base", Package: "java.lang.invoke", Class: "LambdaForm$MH/0x0000000800c0c400", Method: "invoke", File: "LambdaForm$MH"},
+		},
+		{
+			name: "loader without module",
+			line: "at app//com.example.Worker.process(Worker.java:42)",
+			want: StackFrame{ClassLoader: "app", Package: "com.example", Class: "Worker", Method: "process", File: "Worker.java", Line: 42},
+		},
+		{
+			name: "lambda",
+			line: "at com.example.Worker$$Lambda$123/0x0000000800c0b440.run(Unknown Source)",
+			want: StackFrame{Package: "com.example", Class: "Worker$$Lambda$123/0x0000000800c0b440", Method: "run", UnknownSource: true},
+		},
+		{
+			name: "hidden class with module",
+			line: "at java.base@17.0.2/java.lang.invoke.LambdaForm$DMH/0x0000000800c0c000.invokeStatic(LambdaForm$DMH)",
+			want: StackFrame{Module: "java.base@17.0.2", Package: "java.lang.invoke", Class: "LambdaForm$DMH/0x0000000800c0c000", Method: "invokeStatic", File: "LambdaForm$DMH"},
+		},
+		{
+			name: "native",
+			line: "at sun.nio.ch.EPoll.wait(java.base@17.0.2/Native Method)",
+			want: StackFrame{Module: "java.base@17.0.2", Package: "sun.nio.ch", Class: "EPoll", Method: "wait", IsNative: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseFrame(tt.line)
+			if !ok {
+				t.Fatalf("parseFrame(%q) did not match", tt.line)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFrame(%q)\n got  %+v\n want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := parseFrame("- locked <0x000000076ab62208> (a java.lang.Object)"); ok {
+		t.Error("parseFrame matched a lock line")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -11,15 +12,53 @@ import (
 
 // Thread represents a single thread from the dump
 type Thread struct {
-	ID            string   `json:"id"`
-	Name          string   `json:"name"`
-	ThreadPool    string   `json:"thread_pool,omitempty"` // Omits empty pool names before enrichment
-	State         string   `json:"state"`
-	NativeID      int64    `json:"native_id"`
-	StackTrace    []string `json:"stack_trace"`
-	ElapsedTime   float64  `json:"elapsed_time_s"`
-	CPUTime       float64  `json:"cpu_time_ms"`
-	CPUPercentage float64  `json:"cpu_percent"`
+	ID         string       `json:"id"`
+	Name       string       `json:"name"`
+	ThreadPool string       `json:"thread_pool,omitempty"` // Omits empty pool names before enrichment
+	IsPoolIdle bool         `json:"is_pool_idle"`          // Waiting in an idle frame configured for its pool
+	Activity   string       `json:"activity,omitempty"`    // "idle", "io-network", "io-db", "io-file", "lock-contention", "sleeping", "cpu", "waiting"
+	State      string       `json:"state"`
+	NativeID   int64        `json:"native_id"`
+	StackTrace []string     `json:"stack_trace"` // Raw lines, kept for display
+	Frames     []StackFrame `json:"frames"`
+	// j.u.c. locks from the "Locked ownable synchronizers" block of jstack -l
+	OwnedSynchronizers []LockAnnotation `json:"owned_synchronizers,omitempty"`
+	ElapsedTime        float64          `json:"elapsed_time_s"`
+	CPUTime            float64          `json:"cpu_time_ms"`
+	CPUPercentage      float64          `json:"cpu_percent"`
+	HasUsageCPU        bool             `json:"-"` // CPUPercentage was sampled by the usage file
+	IsDeadlocked       bool             `json:"is_deadlocked"`
+
+	// CPU used since the previous dump of the same thread, set when an earlier dump holds it
+	CPUDeltaPercentage float64 `json:"cpu_delta_percent"`
+	HasCPUDelta        bool    `json:"has_cpu_delta"`
+
+	// Java application thread or a thread the JVM runs for itself (GC, JIT, VM operations)
+	Kind             string `json:"kind"`                        // "java", "jvm-internal", "goroutine"
+	InternalCategory string `json:"internal_category,omitempty"` // "gc", "compiler", "vm", "other"
+
+	// Goroutine attributes, ElapsedTime carries the wait duration for them
+	WaitReason         string  `json:"wait_reason,omitempty"` // Go status, e.g. "chan receive", "IO wait"
+	WaitDuration       float64 `json:"wait_duration_s,omitempty"`
+	LockedToThread     bool    `json:"locked_to_thread,omitempty"`
+	CreatedBy          string  `json:"created_by,omitempty"` // Function that started the goroutine
+	CreatedByGoroutine string  `json:"created_by_goroutine,omitempty"`
+
+	// Header attributes
+	SequenceNumber int    `json:"seq,omitempty"` // "#42", the Java thread id
+	IsDaemon       bool   `json:"is_daemon"`
+	HasDaemonFlag  bool   `json:"-"` // The dump tells daemon threads apart, JSON dumps and goroutines do not
+	Priority       int    `json:"priority,omitempty"`
+	OSPriority     int    `json:"os_priority"`
+	StackAddress   string `json:"stack_address,omitempty"` // Top-of-stack address, "[0x00007f...]"
+	Container      string `json:"container,omitempty"`     // Thread container of JSON dumps
+	IsVirtual      bool   `json:"is_virtual"`
+
+	// Virtual thread relationships
+	IsCarrier        bool   `json:"is_carrier"`
+	CarrierID        string `json:"carrier_id,omitempty"`         // Carrier a virtual thread is mounted on
+	MountedVirtualID string `json:"mounted_virtual_id,omitempty"` // Virtual thread a carrier is running
+	IsPinned         bool   `json:"is_pinned"`
 
 	// Fields for Rules Engine
 	RiskLevel      string   `json:"risk_level"` // "CRITICAL", "HIGH", "MEDIUM", "INFO"
@@ -27,14 +66,47 @@ type Thread struct {
 	Recommendation string   `json:"recommendation"`
 }
 
+// ThreadDump holds everything parsed from a single thread dump
+type ThreadDump struct {
+	Format    string
+	Metadata  DumpMetadata
+	Threads   []Thread
+	Deadlocks []Deadlock
+	LockGraph *LockGraph
+
+	// Set when a usage file was correlated with the dump
+	HasUsageData bool
+	// Percent change of the thread count since the first dump of the upload, set from the growth report
+	ThreadCountGrowth float64
+}
+
 // A helper method for Grule to call inside rules
 func (t *Thread) AddIssue(issue string) {
 	t.Issues = append(t.Issues, issue)
 }
 
+// HasFrame reports whether any frame matches a "package.Class.method" or "package.Class" name
+func (t *Thread) HasFrame(name string) bool {
+	for _, f := range t.Frames {
+		if f.QualifiedName() == name || f.QualifiedClass() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TopFrame returns the "package.Class.method" of the innermost frame, or "" for an empty stack
+func (t *Thread) TopFrame() string {
+	if len(t.Frames) == 0 {
+		return ""
+	}
+	return t.Frames[0].QualifiedName()
+}
+
 // ThreadUsage represents thread usage data
 type ThreadUsage struct {
 	CPUPercentage float64 `json:"cpu_percent"`
+	HasCPU        bool    `json:"-"` // False when the file only gives CPU times, e.g. a single /proc task stat capture
 	UserTime      float64 `json:"user_time_ms"`
 	TID           int64   `json:"tid"`
 }
@@ -46,43 +118,152 @@ type GlobalStats struct {
 	BlockedPercentage   float64
 	ThreadCountGrowth   float64
 	IsUsageDataProvided bool
+	JavaMajorVersion    int // 0 when the dump does not name its JVM
+	VMName              string
+	ShutdownInProgress  bool    // Shutdown hooks or Runtime.exit are on a stack
+	HeapUsedPercent     float64 // 0 when the dump has no heap summary
+	MetaspaceUsedKB     int64
+	JNIGlobalRefs       int
+	GCCPUPercentage     float64 // Summed CPU of the GC threads
+}
+
+// Format is called by the rules to print a figure with at most one decimal, string concatenation would print "65.000000"
+func (g *GlobalStats) Format(value float64) string {
+	return strconv.FormatFloat(math.Round(value*10)/10, 'f', -1, 64)
 }
 
 var (
-	//Captures Thread name and Thread ID
-	threadHeaderRE = regexp.MustCompile(`^"(.+?)"\s+.*tid=(\S+)`)
+	//Captures Thread name and Thread ID, JVM internal threads may omit the tid
+	threadHeaderRE = regexp.MustCompile(`^"(.+?)"(?:\s+.*tid=(\S+))?`)
 	//Captures Native Thread ID in hex
 	nidRE = regexp.MustCompile(`nid=0[xX]([0-9a-fA-F]+)`)
+	//Captures the state text following the nid, e.g. "runnable" or "waiting on condition"
+	nativeStateRE = regexp.MustCompile(`nid=\S+\s+([a-zA-Z][a-zA-Z. ()]*?)\s*(?:\[|$)`)
 	//Captures Thread State
 	stateRE = regexp.MustCompile(`\s*java\.lang\.Thread\.State:\s+(.+)`)
 	//Captures Stack Trace lines
-	stackLineRE = regexp.MustCompile(`^\s+(at\s+|-\s+locked|\+?\s*waiting).*`)
+	stackLineRE = regexp.MustCompile(`^\s+(at\s+|-\s+(locked|waiting|parking|eliminated)).*`)
 	//Captures cpu attribute
 	cpuAttributeRE = regexp.MustCompile(`cpu=([\d\.]+)\s*(ms|s|ns)?`)
+	//Captures an entry of the "Locked ownable synchronizers" block
+	synchronizerLineRE = regexp.MustCompile(`^\s*-\s+<(0[xX][0-9a-fA-F]+)>\s+\(a ([^)]+)\)`)
+	//Captures the "#42" sequence number
+	sequenceRE = regexp.MustCompile(`^\s+#(\d+)\b`)
+	//Captures the daemon flag
+	daemonRE = regexp.MustCompile(`\sdaemon\b`)
+	//Captures Java priority
+	priorityRE = regexp.MustCompile(`\sprio=(\d+)`)
+	//Captures OS priority
+	osPriorityRE = regexp.MustCompile(`os_prio=(-?\d+)`)
+	//Captures the top-of-stack address at the end of the header
+	stackAddressRE = regexp.MustCompile(`\[(0[xX][0-9a-fA-F]+)\]\s*$`)
 	//Captures elapsed attribute
 	elapsedAttributeRE = regexp.MustCompile(`elapsed=([\d\.]+)\s*(ms|s)?`)
+	//Captures the timestamp line jstack and kill -3 print above each dump
+	dumpTimestampRE = regexp.MustCompile(`^\s*(\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2})\s*$`)
+	//Captures the banner that opens every dump
+	dumpBannerRE = regexp.MustCompile(`^\s*Full thread dump`)
 )
 
 /* Parsing Thread Dumps */
 
-func ParseThread(r io.Reader) ([]Thread, error) {
+// ParseThread reads HotSpot text dumps. A file holding several consecutive dumps
+// (repeated jstack runs or kill -3 output in a log) yields one ThreadDump per snapshot.
+func ParseThread(r io.Reader) ([]*ThreadDump, error) {
+	var dumps []*ThreadDump
 	var threads []Thread
 	var currentThread *Thread
+	var deadlockSection deadlockSectionParser
+	var heapSection heapSectionParser
+	var metadata DumpMetadata
+	var pendingTimestamp string
+	var javaVersion string   // From a version line above the dumps, it applies to every snapshot of the file
+	inSynchronizers := false // Inside the "Locked ownable synchronizers" block of the current thread
+	hasJavaState := false    // The current thread printed a "java.lang.Thread.State" line
 
 	scanner := bufio.NewScanner(r)
 	buf := make([]byte, 0, 64*1024)
 	scanner.Buffer(buf, 1024*1024)
 
+	flushThread := func() {
+		if currentThread != nil {
+			// Only Java threads carry a sequence number, a Java state or Java frames
+			if !hasJavaState && currentThread.SequenceNumber == 0 && len(currentThread.StackTrace) == 0 {
+				currentThread.Kind = ThreadKindJVMInternal
+			}
+			threads = append(threads, *currentThread)
+		}
+		currentThread = nil
+		hasJavaState = false
+	}
+
+	// finishDump closes the snapshot collected so far
+	finishDump := func() {
+		flushThread()
+		deadlockSection.flush()
+
+		// Flag the threads taking part in a reported cycle
+		markDeadlockedThreads(threads, deadlockSection.deadlocks)
+		metadata.Memory = heapSection.memory
+
+		dumps = append(dumps, &ThreadDump{
+			Format:    FormatHotSpot,
+			Metadata:  metadata,
+			Threads:   threads,
+			Deadlocks: deadlockSection.deadlocks,
+		})
+		threads = nil
+		metadata = DumpMetadata{}
+		deadlockSection = deadlockSectionParser{}
+		heapSection = heapSectionParser{}
+	}
+
 	for scanner.Scan() {
 		line := scanner.Text()
 
+		// A timestamp is only kept when the banner follows it
+		if m := dumpTimestampRE.FindStringSubmatch(line); len(m) >= 2 {
+			pendingTimestamp = m[1]
+			continue
+		}
+		if version, ok := parseJavaVersionLine(line); ok {
+			javaVersion = version
+			continue
+		}
+
+		// Check for the start of the next snapshot
+		if dumpBannerRE.MatchString(line) {
+			if len(threads) > 0 || currentThread != nil {
+				finishDump()
+			}
+			metadata.CaptureTime = parseHotSpotTimestamp(pendingTimestamp)
+			parseHotSpotBanner(line, javaVersion, &metadata)
+			pendingTimestamp = ""
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			pendingTimestamp = ""
+		}
+
+		// Deadlock reports follow the thread entries and reuse the quoted name syntax
+		if deadlockSection.consume(line) {
+			flushThread()
+			continue
+		}
+
+		// JNI references and the Heap block close a kill -3 dump
+		if heapSection.consume(line) {
+			flushThread()
+			continue
+		}
+
 		// Check for thread header
 		if strings.HasPrefix(line, `"`) {
-			if currentThread != nil {
-				threads = append(threads, *currentThread)
-			}
+			flushThread()
+			inSynchronizers = false
 			match := threadHeaderRE.FindStringSubmatch(line)
-			if len(match) >= 3 {
+			// Quoted lines without any attributes are not thread entries
+			if len(match) >= 3 && (match[2] != "" || nidRE.MatchString(line)) {
 				t := &Thread{
 					Name:       match[1],
 					ID:         match[2],
@@ -90,11 +271,23 @@ func ParseThread(r io.Reader) ([]Thread, error) {
 					Issues:     []string{}, // Initialize empty
 				}
 
+				// Extract the remaining header attributes, read after the name so a name cannot fake them
+				parseHeaderAttributes(line[len(match[1])+2:], t)
+
 				// Extract Native ID
 				if nidMatch := nidRE.FindStringSubmatch(line); len(nidMatch) >= 2 {
 					if val, err := strconv.ParseInt(nidMatch[1], 16, 64); err == nil {
 						t.NativeID = val
 					}
+					// Without a tid the native id is the only identity
+					if t.ID == "" {
+						t.ID = "nid=0x" + nidMatch[1]
+					}
+				}
+
+				// The header state is all internal threads have, Java threads override it with their state line
+				if m := nativeStateRE.FindStringSubmatch(line[len(match[1])+2:]); len(m) >= 2 {
+					t.State = nativeStates[m[1]]
 				}
 
 				// Extract CPU Time
@@ -141,6 +334,7 @@ func ParseThread(r io.Reader) ([]Thread, error) {
 		if strings.Contains(line, "java.lang.Thread.State") {
 			match := stateRE.FindStringSubmatch(line)
 			if len(match) >= 2 {
+				hasJavaState = true
 				rawState := strings.TrimSpace(match[1])
 				// Split by space and take the first part to remove things like "(on object monitor)"
 				parts := strings.Split(rawState, " ")
@@ -152,119 +346,140 @@ func ParseThread(r io.Reader) ([]Thread, error) {
 			continue
 		}
 
-		// Check for Stacktrace
-		if stackLineRE.MatchString(line) {
-			currentThread.StackTrace = append(currentThread.StackTrace, strings.TrimSpace(line))
-		}
-	}
-
-	if currentThread != nil {
-		threads = append(threads, *currentThread)
-	}
-
-	return threads, scanner.Err()
-}
-
-/* Parsing Thread Usage */
-
-func ParseThreadUsage(r io.Reader) ([]ThreadUsage, error) {
-	var usages []ThreadUsage
-	scanner := bufio.NewScanner(r)
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		columns := strings.Fields(line)
-
-		// 4 columns: PID, TID, %CPU, TIME
-		if len(columns) < 4 {
+		// Check for the virtual thread a carrier is running
+		if m := carryingVirtualRE.FindStringSubmatch(line); len(m) >= 2 {
+			currentThread.MountedVirtualID = m[1]
 			continue
 		}
-		if columns[0] == "PID" {
+
+		// Check for the ownable synchronizers block that closes a thread entry in jstack -l
+		if strings.TrimSpace(line) == "Locked ownable synchronizers:" {
+			inSynchronizers = true
 			continue
 		}
-
-		// Parse TID
-		tidRaw := columns[1]
-		var tidInt int64
-		// Handle hex and decimal TID
-		if strings.HasPrefix(strings.ToLower(tidRaw), "0x") {
-			val, err := strconv.ParseInt(tidRaw[2:], 16, 64)
-			if err != nil {
-				continue
-			}
-			tidInt = val
-		} else {
-			val, err := strconv.Atoi(tidRaw)
-			if err != nil {
-				continue
+		if inSynchronizers {
+			if m := synchronizerLineRE.FindStringSubmatch(line); len(m) >= 3 {
+				currentThread.OwnedSynchronizers = append(currentThread.OwnedSynchronizers, LockAnnotation{
+					Action:  SynchronizerOwned,
+					Address: m[1],
+					Class:   strings.TrimSpace(m[2]),
+				})
 			}
-			tidInt = int64(val)
+			continue
 		}
 
-		// Parse CPU Percentage
-		cpuStr := strings.Trim(columns[2], " %")
-		cpuVal, err := strconv.ParseFloat(cpuStr, 64)
-		if err != nil {
-			continue
+		// Check for Stacktrace
+		if stackLineRE.MatchString(line) {
+			currentThread.StackTrace = append(currentThread.StackTrace, strings.TrimSpace(line))
 		}
+	}
 
-		timeSeconds := parseTime(columns[3])
-		timeMs := timeSeconds * 1000
+	finishDump()
 
-		usages = append(usages, ThreadUsage{
-			TID:           tidInt,
-			CPUPercentage: cpuVal,
-			UserTime:      timeMs,
-		})
-	}
-	return usages, nil
+	return dumps, scanner.Err()
 }
 
-func parseTime(t string) float64 {
-	t = strings.TrimSpace(t)
-	if strings.Contains(t, ":") {
-		parts := strings.Split(t, ":")
-		var totalSeconds float64
-		multiplier := 1.0
-		for i := len(parts) - 1; i >= 0; i-- {
-			val, err := strconv.ParseFloat(parts[i], 64)
-			if err == nil {
-				totalSeconds += val * multiplier
-			}
-			multiplier *= 60
-		}
-		return totalSeconds
+// parseHeaderAttributes reads sequence number, daemon flag, priorities and stack address
+func parseHeaderAttributes(attrs string, t *Thread) {
+	if m := sequenceRE.FindStringSubmatch(attrs); len(m) >= 2 {
+		t.SequenceNumber, _ = strconv.Atoi(m[1])
+		// Java threads print "daemon" after the sequence number when they are one, JVM internal threads have neither
+		t.HasDaemonFlag = true
+	}
+	t.IsDaemon = daemonRE.MatchString(attrs)
+	if m := priorityRE.FindStringSubmatch(attrs); len(m) >= 2 {
+		t.Priority, _ = strconv.Atoi(m[1])
+	}
+	if m := osPriorityRE.FindStringSubmatch(attrs); len(m) >= 2 {
+		t.OSPriority, _ = strconv.Atoi(m[1])
+	}
+	if m := stackAddressRE.FindStringSubmatch(attrs); len(m) >= 2 {
+		t.StackAddress = m[1]
 	}
-	val, _ := strconv.ParseFloat(t, 64)
-	return val
 }
 
 /* Correlation Logic */
 
-func ProcessAndCorrelate(dumpReader, usageReader io.Reader) ([]Thread, error) {
-	threads, err := ParseThread(dumpReader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse dump: %w", err)
+// ProcessAndCorrelate parses one uploaded dump file, which may hold several snapshots,
+// and applies the usage data to each of them. An unreadable usage file does not stop the
+// analysis, the dumps are returned without usage data together with the usage error.
+func ProcessAndCorrelate(dumpReader, usageReader io.Reader) ([]*ThreadDump, error) {
+	// Pick the reader matching the dump layout
+	br := bufio.NewReaderSize(dumpReader, formatScanLimit)
+	var dumps []*ThreadDump
+	switch DetectFormat(br) {
+	case FormatJSON:
+		dump, err := ParseJSONThreadDump(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dump: %w", err)
+		}
+		dumps = []*ThreadDump{dump}
+	case FormatJavacore:
+		dump, err := ParseJavacore(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dump: %w", err)
+		}
+		dumps = []*ThreadDump{dump}
+	case FormatGoroutine:
+		dump, err := ParseGoroutineDump(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dump: %w", err)
+		}
+		dumps = []*ThreadDump{dump}
+	default:
+		parsed, err := ParseThread(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dump: %w", err)
+		}
+		dumps = parsed
 	}
 
+	var usageMap map[int64]ThreadUsage
+	var usageErr error
 	if usageReader != nil {
 		usages, err := ParseThreadUsage(usageReader)
-		if err == nil {
-			usageMap := make(map[int64]ThreadUsage)
+		if err != nil {
+			usageErr = fmt.Errorf("usage data ignored: %w", err)
+		} else {
+			for _, dump := range dumps {
+				dump.HasUsageData = true
+			}
+			usageMap = make(map[int64]ThreadUsage)
 			for _, u := range usages {
 				usageMap[u.TID] = u
 			}
-			for i := range threads {
-				t := &threads[i]
-				if usage, found := usageMap[t.NativeID]; found {
-					t.CPUPercentage = usage.CPUPercentage
-					if usage.UserTime > 0 {
-						t.CPUTime = usage.UserTime
-					}
+		}
+	}
+
+	for _, dump := range dumps {
+		threads := dump.Threads
+
+		// Structure the raw stack lines once so later stages can match on class and method
+		for i := range threads {
+			// Readers that structure frames themselves (goroutine dumps) leave them in place
+			if threads[i].Frames == nil {
+				threads[i].Frames = ParseFrames(threads[i].StackTrace)
+			}
+			classifyThread(&threads[i])
+		}
+
+		// Link lock annotations into a wait-for graph, which also catches cycles the JVM did not report
+		dump.LockGraph = BuildLockGraph(threads)
+
+		// Connect virtual threads with their carriers and detect pinning
+		linkVirtualThreads(threads)
+
+		for i := range threads {
+			t := &threads[i]
+			if usage, found := usageMap[t.NativeID]; found {
+				t.CPUPercentage = usage.CPUPercentage
+				t.HasUsageCPU = usage.HasCPU
+				if usage.UserTime > 0 {
+					t.CPUTime = usage.UserTime
 				}
 			}
 		}
 	}
 
-	return threads, nil
+	return dumps, usageErr
 }
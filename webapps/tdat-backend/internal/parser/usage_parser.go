@@ -0,0 +1,237 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Usage file layouts recognised by ParseThreadUsage
+const (
+	UsageFormatTop     = "top"     // top -H -b -n1 [-p <pid>]
+	UsageFormatPS      = "ps"      // ps -eLo / ps -L -o with any column selection, includes the legacy "PID TID %CPU TIME"
+	UsageFormatPidstat = "pidstat" // pidstat -t [-p <pid>]
+)
+
+// ErrUnknownUsageFormat is returned when a usage file matches none of the supported layouts
+var ErrUnknownUsageFormat = errors.New("unrecognised thread usage format, expected output of top -H -b, ps -eLo, pidstat -t or /proc/<pid>/task/*/stat")
+
+// usageLayout says which column holds what, resolved from a header line
+type usageLayout struct {
+	format string
+	tid    int
+	cpu    int
+	time   int // -1 when the tool prints no cumulative CPU time (pidstat)
+}
+
+var (
+	//Captures the banner of top batch output, e.g. "top - 10:00:00 up 1 day,  2:03,  1 user"
+	topBannerRE = regexp.MustCompile(`^top - \d{1,2}:\d{2}:\d{2}`)
+	//Captures the sample time that starts pidstat lines, with an optional AM/PM
+	sampleTimeRE = regexp.MustCompile(`^\d{1,2}:\d{2}:\d{2}$`)
+)
+
+// Header names of each column, checked in order
+var (
+	tidColumnNames  = []string{"TID", "LWP", "SPID"}
+	cpuColumnNames  = []string{"%CPU", "PCPU", "C"}
+	timeColumnNames = []string{"TIME+", "TIME", "CPUTIME", "CPUTIME+"}
+)
+
+/* Parsing Thread Usage */
+
+// ParseThreadUsage reads per-thread CPU usage. The layout is detected from the header line and columns
+// are mapped by name, so ps column selections may come in any order. Repeated samples (top -n2,
+// pidstat intervals and their "Average:" block) overwrite earlier values of the same thread.
+// Captures of /proc/<pid>/task/*/stat have no header and are recognised by their lines.
+func ParseThreadUsage(r io.Reader) ([]ThreadUsage, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if isProcTaskStat(lines) {
+		return parseProcTaskStat(lines)
+	}
+
+	var usages []ThreadUsage
+	index := make(map[int64]int) // tid -> position in usages
+	var layout *usageLayout
+	sawTopBanner := false
+
+	for _, raw := range lines {
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+		if topBannerRE.MatchString(line) {
+			sawTopBanner = true
+			continue
+		}
+		columns := trimSampleTime(strings.Fields(line))
+		if len(columns) == 0 {
+			continue
+		}
+
+		// Check for a header line, it sets the layout of the rows below it
+		if detected := detectUsageLayout(columns, sawTopBanner); detected != nil {
+			layout = detected
+			continue
+		}
+
+		var usage ThreadUsage
+		var ok bool
+		if layout != nil {
+			usage, ok = parseUsageRow(columns, layout)
+		} else {
+			usage, ok = parseLegacyUsageRow(columns)
+		}
+		if !ok {
+			continue
+		}
+		if i, seen := index[usage.TID]; seen {
+			usages[i] = usage
+		} else {
+			index[usage.TID] = len(usages)
+			usages = append(usages, usage)
+		}
+	}
+	if len(usages) == 0 && layout == nil {
+		return nil, ErrUnknownUsageFormat
+	}
+	return usages, nil
+}
+
+// detectUsageLayout recognises a header line, it returns nil for data and summary lines
+func detectUsageLayout(columns []string, afterTopBanner bool) *usageLayout {
+	cpu := columnIndex(columns, cpuColumnNames)
+	if cpu < 0 {
+		return nil
+	}
+	layout := &usageLayout{cpu: cpu, time: columnIndex(columns, timeColumnNames)}
+
+	layout.tid = columnIndex(columns, tidColumnNames)
+	switch {
+	case columnIndex(columns, []string{"TGID"}) >= 0 && layout.tid >= 0:
+		layout.format = UsageFormatPidstat
+	case layout.tid >= 0:
+		layout.format = UsageFormatPS
+	case afterTopBanner || columnIndex(columns, []string{"TIME+"}) >= 0:
+		// With -H top lists threads, its PID column holds the thread id
+		layout.tid = columnIndex(columns, []string{"PID"})
+		layout.format = UsageFormatTop
+	}
+	if layout.tid < 0 || layout.format == "" {
+		return nil
+	}
+	return layout
+}
+
+// parseUsageRow reads a data row, rows of processes and summaries have no numeric thread id and are skipped
+func parseUsageRow(columns []string, layout *usageLayout) (ThreadUsage, bool) {
+	if layout.tid >= len(columns) || layout.cpu >= len(columns) {
+		return ThreadUsage{}, false
+	}
+	tid, ok := parseTID(columns[layout.tid])
+	if !ok {
+		return ThreadUsage{}, false
+	}
+	cpuVal, err := strconv.ParseFloat(strings.Trim(columns[layout.cpu], " %"), 64)
+	if err != nil {
+		return ThreadUsage{}, false
+	}
+	usage := ThreadUsage{TID: tid, CPUPercentage: cpuVal, HasCPU: true}
+	if layout.time >= 0 && layout.time < len(columns) {
+		usage.UserTime = parseTime(columns[layout.time]) * 1000
+	}
+	return usage, true
+}
+
+// parseLegacyUsageRow reads the headerless 4 columns: PID, TID, %CPU, TIME
+func parseLegacyUsageRow(columns []string) (ThreadUsage, bool) {
+	if len(columns) < 4 {
+		return ThreadUsage{}, false
+	}
+	tid, ok := parseTID(columns[1])
+	if !ok {
+		return ThreadUsage{}, false
+	}
+	cpuVal, err := strconv.ParseFloat(strings.Trim(columns[2], " %"), 64)
+	if err != nil {
+		return ThreadUsage{}, false
+	}
+	return ThreadUsage{
+		TID:           tid,
+		CPUPercentage: cpuVal,
+		HasCPU:        true,
+		UserTime:      parseTime(columns[3]) * 1000,
+	}, true
+}
+
+// parseTID handles hex and decimal thread ids
+func parseTID(raw string) (int64, bool) {
+	if strings.HasPrefix(strings.ToLower(raw), "0x") {
+		val, err := strconv.ParseInt(raw[2:], 16, 64)
+		return val, err == nil
+	}
+	val, err := strconv.ParseInt(raw, 10, 64)
+	return val, err == nil
+}
+
+// trimSampleTime drops the "10:00:01 AM" or "Average:" columns pidstat puts in front of every line
+func trimSampleTime(columns []string) []string {
+	if len(columns) > 0 && columns[0] == "Average:" {
+		return columns[1:]
+	}
+	if len(columns) > 0 && sampleTimeRE.MatchString(columns[0]) {
+		columns = columns[1:]
+		if len(columns) > 0 && (columns[0] == "AM" || columns[0] == "PM") {
+			columns = columns[1:]
+		}
+	}
+	return columns
+}
+
+func columnIndex(columns []string, names []string) int {
+	for _, name := range names {
+		for i, col := range columns {
+			if strings.EqualFold(col, name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseTime reads "[[dd-]hh:]mm:ss[.xx]" or plain seconds into seconds
+func parseTime(t string) float64 {
+	t = strings.TrimSpace(t)
+	var days float64
+	if d, rest, found := strings.Cut(t, "-"); found && strings.Contains(rest, ":") {
+		days, _ = strconv.ParseFloat(d, 64)
+		t = rest
+	}
+	if strings.Contains(t, ":") {
+		parts := strings.Split(t, ":")
+		var totalSeconds float64
+		multiplier := 1.0
+		for i := len(parts) - 1; i >= 0; i-- {
+			val, err := strconv.ParseFloat(parts[i], 64)
+			if err == nil {
+				totalSeconds += val * multiplier
+			}
+			multiplier *= 60
+		}
+		return days*86400 + totalSeconds
+	}
+	val, _ := strconv.ParseFloat(t, 64)
+	return val
+}
@@ -0,0 +1,108 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	//Captures the virtual thread id a carrier is running, JDK 21+ jstack
+	carryingVirtualRE = regexp.MustCompile(`^\s*Carrying virtual thread #(\d+)`)
+)
+
+/* Virtual Thread Modelling */
+
+// linkVirtualThreads connects virtual threads with their carriers and flags pinned threads.
+func linkVirtualThreads(threads []Thread) {
+	byID := make(map[string]int)
+	for i := range threads {
+		if threads[i].ID != "" {
+			byID[threads[i].ID] = i
+		}
+	}
+
+	for i := range threads {
+		t := &threads[i]
+
+		// A carrier names the virtual thread it runs, a virtual thread names its carrier
+		if t.MountedVirtualID != "" {
+			t.IsCarrier = true
+			if idx, found := byID[t.MountedVirtualID]; found && threads[idx].IsVirtual {
+				threads[idx].CarrierID = t.ID
+			}
+		}
+		if t.IsVirtual && t.CarrierID != "" {
+			if idx, found := byID[t.CarrierID]; found {
+				threads[idx].IsCarrier = true
+				threads[idx].MountedVirtualID = t.ID
+			}
+		}
+
+		// Older dumps name neither side, a continuation frame still gives the carrier away
+		if !t.IsVirtual && hasContinuationFrame(t.StackTrace) {
+			t.IsCarrier = true
+		}
+	}
+
+	for i := range threads {
+		t := &threads[i]
+		if !isPinned(t) {
+			continue
+		}
+		t.IsPinned = true
+		// Pinning holds both sides, mark the partner as well
+		if t.IsVirtual && t.CarrierID != "" {
+			if idx, found := byID[t.CarrierID]; found {
+				threads[idx].IsPinned = true
+			}
+		} else if t.IsCarrier && t.MountedVirtualID != "" {
+			if idx, found := byID[t.MountedVirtualID]; found {
+				threads[idx].IsPinned = true
+			}
+		}
+	}
+}
+
+// isPinned reports a virtual thread (or a carrier running one) that is blocked while holding a monitor
+func isPinned(t *Thread) bool {
+	if !t.IsVirtual && !(t.IsCarrier && t.MountedVirtualID != "") {
+		return false
+	}
+	// Parking on the carrier is what the JDK does when it cannot unmount
+	for _, line := range t.StackTrace {
+		if strings.Contains(line, "java.lang.VirtualThread.parkOnCarrierThread") {
+			return true
+		}
+	}
+	if t.State != "BLOCKED" && t.State != "WAITING" && t.State != "TIMED_WAITING" {
+		return false
+	}
+	return holdsMonitor(t.StackTrace)
+}
+
+// holdsMonitor reports whether a stack still owns a monitor, Object.wait() releases the one it waits on
+func holdsMonitor(stack []string) bool {
+	released := make(map[string]bool)
+	for _, line := range stack {
+		if m := lockLineRE.FindStringSubmatch(line); len(m) >= 4 && m[1] == "waiting on" {
+			released[m[2]] = true
+		}
+	}
+	for _, line := range stack {
+		if m := lockLineRE.FindStringSubmatch(line); len(m) >= 4 && m[1] == "locked" && !released[m[2]] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasContinuationFrame reports whether a platform thread is running a virtual thread continuation
+func hasContinuationFrame(stack []string) bool {
+	for _, line := range stack {
+		if strings.Contains(line, "jdk.internal.vm.Continuation.run") ||
+			strings.Contains(line, "java.lang.VirtualThread$VThreadContinuation") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,372 @@
+package upload
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path"
+	"strings"
+)
+
+// Limits against archives that expand far beyond the upload size (zip bombs)
+const (
+	MaxArchiveEntries   = 1000      // Files taken from one archive
+	MaxEntrySize        = 256 << 20 // Uncompressed size of one file
+	MaxTotalSize        = 1 << 30   // Uncompressed size of all files of one archive
+	MaxCompressionRatio = 200       // Uncompressed to compressed size
+)
+
+// Tar entries up to these sizes stay in memory, larger ones are spilled to temporary files
+const (
+	MaxBufferedEntrySize = 1 << 20  // One entry, usage files and small dumps
+	MaxBufferedTotal     = 16 << 20 // All buffered entries of one upload request
+)
+
+// ErrTooLarge is returned when a file expands beyond the limits
+var ErrTooLarge = errors.New("uncompressed size exceeds the limit")
+
+// Archive layouts recognised from their leading bytes
+const (
+	kindPlain = iota
+	kindZip
+	kindGzip
+	kindTarGzip
+	kindTar
+)
+
+/* Archive Detection */
+
+// detectKind reads the magic bytes of an upload, names are not trusted
+func detectKind(fh *multipart.FileHeader) (int, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return kindPlain, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(512)
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return kindZip, nil
+	case bytes.HasPrefix(head, []byte("\x1f\x8b")):
+		// A gzip stream holds either a tarball or a single file
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return kindPlain, err
+		}
+		defer zr.Close()
+		inner := make([]byte, 512)
+		n, _ := io.ReadFull(zr, inner)
+		if isTarHeader(inner[:n]) {
+			return kindTarGzip, nil
+		}
+		return kindGzip, nil
+	case isTarHeader(head):
+		return kindTar, nil
+	}
+	return kindPlain, nil
+}
+
+func isTarHeader(block []byte) bool {
+	return len(block) >= 262 && bytes.HasPrefix(block[257:], []byte("ustar"))
+}
+
+/* Unpacking */
+
+// expandZip lists the files of a zip upload, each entry is decompressed only when opened
+func expandZip(fh *multipart.FileHeader) ([]Source, []error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer f.Close()
+
+	zr, err := zip.NewReader(f, fh.Size)
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s: not a readable zip archive: %w", fh.Filename, err)}
+	}
+
+	var sources []Source
+	var problems []error
+	var total uint64
+	for idx, entry := range zr.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		name, err := entryName(entry.Name)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", fh.Filename, err))
+			continue
+		}
+		if skipEntry(name) {
+			continue
+		}
+		if len(sources) >= MaxArchiveEntries {
+			problems = append(problems, fmt.Errorf("%s: more than %d files, the rest is ignored", fh.Filename, MaxArchiveEntries))
+			break
+		}
+
+		// Sizes from the central directory are checked first, the zip reader enforces them while reading
+		size := entry.UncompressedSize64
+		if size > MaxEntrySize || (entry.CompressedSize64 > 0 && size/entry.CompressedSize64 > MaxCompressionRatio) {
+			problems = append(problems, fmt.Errorf("%s/%s: %w", fh.Filename, name, ErrTooLarge))
+			continue
+		}
+		total += size
+		if total > MaxTotalSize {
+			problems = append(problems, fmt.Errorf("%s: %w, the remaining files are ignored", fh.Filename, ErrTooLarge))
+			break
+		}
+
+		sources = append(sources, Source{
+			Name:        fh.Filename + "/" + name,
+			FromArchive: true,
+			Open:        openZipEntry(fh, idx),
+		})
+	}
+	return sources, problems
+}
+
+// openZipEntry reopens the upload for each read so no file handle outlives the request handling
+func openZipEntry(fh *multipart.FileHeader, idx int) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(f, fh.Size)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		entry, err := zr.File[idx].Open()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: limitReader(entry, MaxEntrySize), closers: []io.Closer{entry, f}}, nil
+	}
+}
+
+// expandTar unpacks the files of a tarball once, tar is sequential so entries cannot be opened later on their own.
+// Small entries are kept in memory, the others go to temporary files of the spool.
+func expandTar(fh *multipart.FileHeader, gzipped bool, sp *spool) ([]Source, []error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, []error{fmt.Errorf("%s: %w", fh.Filename, err)}
+		}
+		defer zr.Close()
+		r = zr
+	}
+	// The whole stream is bounded, not only each entry
+	total := limitReader(r, totalLimit(fh.Size))
+
+	var sources []Source
+	var problems []error
+	tr := tar.NewReader(total)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", fh.Filename, err))
+			break
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue // Directories, links and devices
+		}
+		name, err := entryName(hdr.Name)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", fh.Filename, err))
+			continue
+		}
+		if skipEntry(name) {
+			continue
+		}
+		if len(sources) >= MaxArchiveEntries {
+			problems = append(problems, fmt.Errorf("%s: more than %d files, the rest is ignored", fh.Filename, MaxArchiveEntries))
+			break
+		}
+		if hdr.Size > MaxEntrySize {
+			problems = append(problems, fmt.Errorf("%s/%s: %w", fh.Filename, name, ErrTooLarge))
+			continue
+		}
+
+		open, err := sp.store(tr, hdr.Size)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s/%s: %w", fh.Filename, name, err))
+			break
+		}
+		sources = append(sources, Source{
+			Name:        fh.Filename + "/" + name,
+			FromArchive: true,
+			Open:        open,
+		})
+	}
+	return sources, problems
+}
+
+// spool holds the unpacked tar entries of one upload request until Cleanup
+type spool struct {
+	dir      string // Created on the first spilled entry
+	buffered int64
+}
+
+// store keeps an entry in memory when small enough, otherwise it copies it to a temporary file
+func (sp *spool) store(r io.Reader, size int64) (func() (io.ReadCloser, error), error) {
+	if size <= MaxBufferedEntrySize && sp.buffered+size <= MaxBufferedTotal {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		sp.buffered += int64(len(data))
+		return func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}, nil
+	}
+
+	if sp.dir == "" {
+		dir, err := os.MkdirTemp("", "tdat-upload-")
+		if err != nil {
+			return nil, err
+		}
+		sp.dir = dir
+	}
+	f, err := os.CreateTemp(sp.dir, "entry-")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return nil, err
+	}
+	tmpPath := f.Name()
+	return func() (io.ReadCloser, error) { return os.Open(tmpPath) }, nil
+}
+
+// Cleanup removes the spilled entries, sources of the upload cannot be opened afterwards
+func (sp *spool) Cleanup() {
+	if sp.dir != "" {
+		os.RemoveAll(sp.dir)
+		sp.dir = ""
+	}
+}
+
+// openGzip decompresses a single gzipped file on each open
+func openGzip(fh *multipart.FileHeader) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: limitReader(zr, totalLimit(fh.Size)), closers: []io.Closer{zr, f}}, nil
+	}
+}
+
+/* Safeguards */
+
+// entryName cleans an archive path and rejects absolute paths and paths leaving the archive root
+func entryName(raw string) (string, error) {
+	name := strings.ReplaceAll(raw, `\`, "/")
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return "", fmt.Errorf("entry %q has an absolute path", raw)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("entry %q points outside the archive", raw)
+		}
+	}
+	return path.Clean(name), nil
+}
+
+// skipEntry leaves out metadata that archivers add, archives nested in archives and the files of
+// /proc/<pid>/task/<tid> other than stat, so they do not count against MaxArchiveEntries
+func skipEntry(name string) bool {
+	base := path.Base(name)
+	if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+		return true
+	}
+	if m := taskEntryRE.FindStringSubmatch(name); len(m) >= 3 && m[2] != "stat" {
+		return true
+	}
+	lower := strings.ToLower(base)
+	for _, ext := range []string{".zip", ".tar", ".tgz", ".tar.gz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// totalLimit caps what a compressed upload may expand to
+func totalLimit(compressedSize int64) int64 {
+	limit := compressedSize * MaxCompressionRatio
+	if limit <= 0 || limit > MaxTotalSize {
+		return MaxTotalSize
+	}
+	return limit
+}
+
+// limitReader fails with ErrTooLarge instead of silently truncating like io.LimitReader
+func limitReader(r io.Reader, limit int64) io.Reader {
+	return &limitedReader{r: r, remaining: limit}
+}
+
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrTooLarge
+	}
+	// Read one byte past the limit to tell "exactly at the limit" from "over it"
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// readCloser closes the decompressor together with the underlying upload
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (rc *readCloser) Close() error {
+	var first error
+	for _, c := range rc.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,252 @@
+package upload
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"os"
+	"testing"
+)
+
+// archiveFile is one entry of an archive built by the tests
+type archiveFile struct {
+	name string
+	data []byte
+}
+
+func buildZip(t *testing.T, files []archiveFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTarGz(t *testing.T, files []archiveFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), Typeflag: tar.TypeReg, Format: tar.FormatUSTAR}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// fileHeader uploads data through a multipart form, the way the handler receives it
+func fileHeader(t *testing.T, name string, data []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	w, err := mw.CreateFormFile("thread_dumps", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	form, err := multipart.NewReader(&body, mw.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["thread_dumps"][0]
+}
+
+func sourceNames(sources []Source) []string {
+	var names []string
+	for _, s := range sources {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func readSource(t *testing.T, s Source) []byte {
+	t.Helper()
+	rc, err := s.Open()
+	if err != nil {
+		t.Fatalf("open %s: %v", s.Name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read %s: %v", s.Name, err)
+	}
+	return data
+}
+
+func hasTooLarge(problems []error) bool {
+	for _, p := range problems {
+		if errors.Is(p, ErrTooLarge) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCollectRejectsTraversal(t *testing.T) {
+	files := []archiveFile{
+		{"../escape.txt", []byte("x")},
+		{"dumps/../../escape.txt", []byte("x")},
+		{"/etc/passwd", []byte("x")},
+		{`C:\windows\dump.txt`, []byte("x")},
+		{"node1/dump_1.txt", []byte("dump")},
+	}
+	builders := map[string]func(*testing.T, []archiveFile) []byte{
+		"bundle.zip":    buildZip,
+		"bundle.tar.gz": buildTarGz,
+	}
+	for name, build := range builders {
+		t.Run(name, func(t *testing.T) {
+			dumps, usages, cleanup, problems := Collect([]*multipart.FileHeader{fileHeader(t, name, build(t, files))}, nil)
+			defer cleanup()
+
+			want := name + "/node1/dump_1.txt"
+			if len(dumps) != 1 || dumps[0].Name != want || len(usages) != 0 {
+				t.Fatalf("sources = %v %v, want only %s", sourceNames(dumps), sourceNames(usages), want)
+			}
+			if len(problems) != 4 {
+				t.Errorf("got %d problems, want 4: %v", len(problems), problems)
+			}
+			if got := readSource(t, dumps[0]); string(got) != "dump" {
+				t.Errorf("content = %q, want %q", got, "dump")
+			}
+		})
+	}
+}
+
+func TestCollectRejectsOversizedEntries(t *testing.T) {
+	// Zeros compress far beyond MaxCompressionRatio
+	bomb := make([]byte, 4<<20)
+	files := []archiveFile{{"bomb.txt", bomb}, {"dump.txt", []byte("dump")}}
+
+	t.Run("zip", func(t *testing.T) {
+		dumps, _, cleanup, problems := Collect([]*multipart.FileHeader{fileHeader(t, "bundle.zip", buildZip(t, files))}, nil)
+		defer cleanup()
+		if len(dumps) != 1 || dumps[0].Name != "bundle.zip/dump.txt" {
+			t.Errorf("sources = %v, want only bundle.zip/dump.txt", sourceNames(dumps))
+		}
+		if !hasTooLarge(problems) {
+			t.Errorf("problems = %v, want %v", problems, ErrTooLarge)
+		}
+	})
+
+	t.Run("zip size from central directory", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, err := zw.CreateRaw(&zip.FileHeader{Name: "huge.txt", Method: zip.Store, CompressedSize64: MaxEntrySize + 1, UncompressedSize64: MaxEntrySize + 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("x"))
+		zw.Close()
+
+		dumps, _, cleanup, problems := Collect([]*multipart.FileHeader{fileHeader(t, "huge.zip", buf.Bytes())}, nil)
+		defer cleanup()
+		if len(dumps) != 0 || !hasTooLarge(problems) {
+			t.Errorf("sources = %v, problems = %v, want none and %v", sourceNames(dumps), problems, ErrTooLarge)
+		}
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		dumps, _, cleanup, problems := Collect([]*multipart.FileHeader{fileHeader(t, "bundle.tar.gz", buildTarGz(t, files))}, nil)
+		defer cleanup()
+		if len(dumps) != 0 {
+			t.Errorf("sources = %v, want none past the bomb", sourceNames(dumps))
+		}
+		if !hasTooLarge(problems) {
+			t.Errorf("problems = %v, want %v", problems, ErrTooLarge)
+		}
+	})
+}
+
+func TestCollectLimitsEntryCount(t *testing.T) {
+	var files []archiveFile
+	for i := 0; i < MaxArchiveEntries+10; i++ {
+		files = append(files, archiveFile{fmt.Sprintf("dumps/dump_%d.txt", i), []byte("dump")})
+	}
+	// Files of thread directories other than stat do not count
+	for i := 0; i < 50; i++ {
+		files = append([]archiveFile{{fmt.Sprintf("proc/task/%d/status", i), []byte("x")}}, files...)
+	}
+
+	builders := map[string]func(*testing.T, []archiveFile) []byte{
+		"bundle.zip":    buildZip,
+		"bundle.tar.gz": buildTarGz,
+	}
+	for name, build := range builders {
+		t.Run(name, func(t *testing.T) {
+			dumps, _, cleanup, problems := Collect([]*multipart.FileHeader{fileHeader(t, name, build(t, files))}, nil)
+			defer cleanup()
+			if len(dumps) != MaxArchiveEntries {
+				t.Errorf("got %d sources, want %d", len(dumps), MaxArchiveEntries)
+			}
+			if len(problems) != 1 {
+				t.Errorf("problems = %v, want one about the entry count", problems)
+			}
+		})
+	}
+}
+
+func TestSpoolCleanup(t *testing.T) {
+	// Random bytes do not compress, so the entry passes the ratio check and is spilled to disk
+	large := make([]byte, MaxBufferedEntrySize+1024)
+	rand.New(rand.NewSource(1)).Read(large)
+	files := []archiveFile{{"large.txt", large}, {"small.txt", []byte("small")}}
+
+	sp := &spool{}
+	sources, problems := expandTar(fileHeader(t, "bundle.tgz", buildTarGz(t, files)), true, sp)
+	if len(problems) != 0 || len(sources) != 2 {
+		t.Fatalf("sources = %v, problems = %v", sourceNames(sources), problems)
+	}
+	if sp.dir == "" {
+		t.Fatal("large entry was not spilled to a temporary file")
+	}
+	if got := readSource(t, sources[0]); !bytes.Equal(got, large) {
+		t.Error("spilled entry does not read back unchanged")
+	}
+	if got := readSource(t, sources[1]); string(got) != "small" {
+		t.Errorf("buffered entry = %q, want %q", got, "small")
+	}
+
+	dir := sp.dir
+	sp.Cleanup()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("temporary directory %s still exists after Cleanup", dir)
+	}
+	if _, err := sources[0].Open(); err == nil {
+		t.Error("spilled entry can still be opened after Cleanup")
+	}
+}
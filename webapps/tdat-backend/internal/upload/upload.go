@@ -0,0 +1,348 @@
+package upload
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Source is one file of an upload, either uploaded directly or taken from an archive
+type Source struct {
+	Name        string // Upload name, "bundle.zip/node1/dump_1.txt" for archive entries
+	FromArchive bool
+	Open        func() (io.ReadCloser, error)
+}
+
+// Pair is a dump and the usage file captured with it, Usage is nil when there is none
+type Pair struct {
+	Dump  Source
+	Usage *Source
+}
+
+var (
+	//Captures usage file names, e.g. "top_1.txt", "usage-node1.log", "ps.out"
+	usageNameRE = regexp.MustCompile(`(?i)(^|[_\-.])(top|usage|usages|ps|pidstat|cpu)([_\-.\d]|$)`)
+	//Captures the words that tell dumps and usage files apart with the separator before them, removed to build the pairing key.
+	//Words only count between separators, "steps" or "dumpster" keep their letters
+	pairingWordsRE = regexp.MustCompile(`(?i)(^|[_\-.])(thread_?dumps?|threaddumps?|dumps?|jstack|javacore|goroutines?|thread_?usages?|usages?|top|pidstat|ps|cpu)([_\-.]|$)`)
+	//Captures the extensions dropped from the pairing key
+	extensionRE = regexp.MustCompile(`(?i)(\.(txt|log|out|json|tdump|dump))+$`)
+	//Captures a date and time embedded in a name, e.g. "20240501_120000", "2024-05-01T12-00-00", "20240501.120000"
+	nameTimestampRE = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})[T_\-. ]?(\d{2})[:\-.]?(\d{2})[:\-.]?(\d{2})`)
+	//Captures epoch seconds embedded in a name, e.g. "top.1714564800.txt"
+	nameEpochRE = regexp.MustCompile(`(?:^|\D)(1\d{9})(?:\D|$)`)
+	//Captures the directory above a copy of /proc/<pid>/task and the file inside a thread directory
+	taskEntryRE = regexp.MustCompile(`^(.*/)?task/\d+/([^/]+)$`)
+)
+
+// TimestampTolerance is how far apart the timestamps in the names of a dump and its usage file may be
+const TimestampTolerance = 2 * time.Minute
+
+/* Collecting Uploads */
+
+// Collect expands the uploaded files into dump and usage sources. Files uploaded directly keep the field
+// they were uploaded in, files taken from archives in either field are sorted by their names.
+// Copies of /proc/<pid>/task inside an archive become one usage source each.
+// Problems with single files or archive entries are returned, the remaining files are still used.
+// The returned cleanup removes the temporary files of unpacked entries, it is called once the sources are read.
+func Collect(dumpHeaders, usageHeaders []*multipart.FileHeader) (dumps, usages []Source, cleanup func(), problems []error) {
+	sp := &spool{}
+	add := func(fh *multipart.FileHeader, fieldIsUsage bool) {
+		sources, errs := expand(fh, sp)
+		problems = append(problems, errs...)
+		sources, taskStats := mergeTaskStats(fh.Filename, sources, fieldIsUsage)
+		usages = append(usages, taskStats...)
+		for _, s := range sources {
+			asUsage := fieldIsUsage
+			if s.FromArchive {
+				asUsage = IsUsageName(s.Name)
+			}
+			if asUsage {
+				usages = append(usages, s)
+			} else {
+				dumps = append(dumps, s)
+			}
+		}
+	}
+	for _, fh := range dumpHeaders {
+		add(fh, false)
+	}
+	for _, fh := range usageHeaders {
+		add(fh, true)
+	}
+	return dumps, usages, sp.Cleanup, problems
+}
+
+// expand turns one uploaded file into the files it holds
+func expand(fh *multipart.FileHeader, sp *spool) ([]Source, []error) {
+	kind, err := detectKind(fh)
+	if err != nil {
+		return nil, []error{err}
+	}
+	switch kind {
+	case kindZip:
+		return expandZip(fh)
+	case kindTarGzip:
+		return expandTar(fh, true, sp)
+	case kindTar:
+		return expandTar(fh, false, sp)
+	case kindGzip:
+		name := strings.TrimSuffix(strings.TrimSuffix(fh.Filename, ".gz"), ".GZ")
+		return []Source{{Name: name, Open: openGzip(fh)}}, nil
+	}
+	return []Source{{
+		Name: fh.Filename,
+		Open: func() (io.ReadCloser, error) { return fh.Open() },
+	}}, nil
+}
+
+// IsUsageName reports whether a file name looks like thread usage output (top, ps, pidstat)
+func IsUsageName(name string) bool {
+	return usageNameRE.MatchString(path.Base(name))
+}
+
+// mergeTaskStats joins the task/<tid>/stat entries of each copied /proc/<pid>/task directory into one source,
+// the other files of the thread directories are skipped while unpacking. Uploaded in the usage field the
+// archive stands for a single usage file, so it pairs like a usage file uploaded directly.
+func mergeTaskStats(archive string, sources []Source, fieldIsUsage bool) (rest, merged []Source) {
+	groups := make(map[string][]Source)
+	var order []string
+	for _, s := range sources {
+		m := taskEntryRE.FindStringSubmatch(strings.TrimPrefix(s.Name, archive+"/"))
+		if !s.FromArchive || len(m) < 3 {
+			rest = append(rest, s)
+			continue
+		}
+		if _, seen := groups[m[1]]; !seen {
+			order = append(order, m[1])
+		}
+		groups[m[1]] = append(groups[m[1]], s)
+	}
+
+	for _, dir := range order {
+		entries := groups[dir]
+		merged = append(merged, Source{
+			Name:        archive + "/" + dir + "task",
+			FromArchive: !fieldIsUsage || len(order) > 1,
+			Open: func() (io.ReadCloser, error) {
+				var data []byte
+				for _, e := range entries {
+					rc, err := e.Open()
+					if err != nil {
+						return nil, err
+					}
+					content, err := io.ReadAll(rc)
+					rc.Close()
+					if err != nil {
+						return nil, fmt.Errorf("%s: %w", e.Name, err)
+					}
+					data = append(data, content...)
+					if len(content) > 0 && content[len(content)-1] != '\n' {
+						data = append(data, '\n')
+					}
+				}
+				return io.NopCloser(bytes.NewReader(data)), nil
+			},
+		})
+	}
+	return rest, merged
+}
+
+/* Pairing */
+
+// ParseMapping reads the explicit pairing field: "dump_a.txt=top_a.txt" entries separated by commas or new lines
+func ParseMapping(raw string) (map[string]string, []error) {
+	mapping := make(map[string]string)
+	var problems []error
+	entries := strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '\n' || r == ';' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		dump, usage, found := strings.Cut(entry, "=")
+		if !found || strings.TrimSpace(dump) == "" || strings.TrimSpace(usage) == "" {
+			problems = append(problems, fmt.Errorf("usage mapping entry %q is not of the form dump=usage", entry))
+			continue
+		}
+		mapping[strings.TrimSpace(dump)] = strings.TrimSpace(usage)
+	}
+	return mapping, problems
+}
+
+// PairSources attaches each dump to its usage file, trying in order:
+//   - the explicit mapping, keyed by full or base name of the dump
+//   - a shared stem, "dump_01.txt" and "usage_01.txt" both become "01"
+//   - the nearest timestamp embedded in the names, within TimestampTolerance
+//
+// A single uploaded dump with a single uploaded usage file is paired as well. Files left without
+// a partner are reported instead of being attached to an unrelated dump.
+func PairSources(dumps, usages []Source, mapping map[string]string) ([]Pair, []error) {
+	pairs := make([]Pair, len(dumps))
+	used := make([]bool, len(usages))
+	var problems []error
+
+	attach := func(i, j int) {
+		pairs[i].Usage = &usages[j]
+		used[j] = true
+	}
+	for i, d := range dumps {
+		pairs[i].Dump = d
+	}
+
+	// Explicit mapping
+	for i, d := range dumps {
+		wanted, found := mapping[d.Name]
+		if !found {
+			wanted, found = mapping[path.Base(d.Name)]
+		}
+		if !found {
+			continue
+		}
+		j := findByName(usages, wanted)
+		if j < 0 {
+			problems = append(problems, fmt.Errorf("usage file %q mapped to dump %s was not uploaded", wanted, d.Name))
+			continue
+		}
+		if used[j] {
+			problems = append(problems, fmt.Errorf("usage file %s is mapped to more than one dump", usages[j].Name))
+			continue
+		}
+		attach(i, j)
+	}
+
+	// Shared stem
+	byKey := make(map[string]int)
+	for j, u := range usages {
+		if used[j] {
+			continue
+		}
+		if _, exists := byKey[pairingKey(u)]; !exists {
+			byKey[pairingKey(u)] = j
+		}
+	}
+	for i, d := range dumps {
+		if pairs[i].Usage != nil {
+			continue
+		}
+		if j, found := byKey[pairingKey(d)]; found && !used[j] {
+			attach(i, j)
+		}
+	}
+
+	// Nearest embedded timestamp
+	for i, d := range dumps {
+		if pairs[i].Usage != nil {
+			continue
+		}
+		dumpTime, ok := nameTimestamp(d.Name)
+		if !ok {
+			continue
+		}
+		best, bestGap := -1, TimestampTolerance+1
+		for j, u := range usages {
+			if used[j] {
+				continue
+			}
+			if usageTime, ok := nameTimestamp(u.Name); ok {
+				gap := dumpTime.Sub(usageTime).Abs()
+				if gap <= TimestampTolerance && gap < bestGap {
+					best, bestGap = j, gap
+				}
+			}
+		}
+		if best >= 0 {
+			attach(i, best)
+		}
+	}
+
+	// One dump and one usage file leave no room for a mix up
+	if len(dumps) == 1 && len(usages) == 1 && pairs[0].Usage == nil && !used[0] &&
+		!dumps[0].FromArchive && !usages[0].FromArchive {
+		attach(0, 0)
+	}
+
+	// Report what is left, only when usage data was uploaded at all
+	if len(usages) > 0 {
+		for _, p := range pairs {
+			if p.Usage == nil {
+				problems = append(problems, fmt.Errorf("no usage file matched dump %s, it is analyzed without usage data", p.Dump.Name))
+			}
+		}
+		for j, u := range usages {
+			if !used[j] {
+				problems = append(problems, fmt.Errorf("usage file %s matched no dump and was ignored", u.Name))
+			}
+		}
+	}
+	return pairs, problems
+}
+
+// findByName looks a source up by its full name, or by its base name when that is unambiguous
+func findByName(sources []Source, name string) int {
+	match := -1
+	for i, s := range sources {
+		if s.Name == name {
+			return i
+		}
+		if path.Base(s.Name) == name {
+			if match >= 0 {
+				return -1
+			}
+			match = i
+		}
+	}
+	return match
+}
+
+// nameTimestamp reads a date and time or epoch seconds from the base name of a file
+func nameTimestamp(name string) (time.Time, bool) {
+	base := path.Base(name)
+	if m := nameTimestampRE.FindStringSubmatch(base); len(m) >= 7 {
+		ts, err := time.Parse("20060102150405", strings.Join(m[1:7], ""))
+		if err == nil {
+			return ts, true
+		}
+	}
+	if m := nameEpochRE.FindStringSubmatch(base); len(m) >= 2 {
+		secs, err := strconv.ParseInt(m[1], 10, 64)
+		if err == nil {
+			return time.Unix(secs, 0).UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// pairingKey keeps the directory inside the archive and the part of the name that is not about the file type,
+// so dumps and usage files may come in separate archives
+func pairingKey(s Source) string {
+	name := s.Name
+	if s.FromArchive {
+		if slash := strings.Index(name, "/"); slash >= 0 {
+			name = name[slash+1:]
+		}
+	}
+	dir, base := path.Split(name)
+	base = extensionRE.ReplaceAllString(base, "")
+	base = stripPairingWords(base)
+	base = strings.Trim(base, "_-. ")
+	return dir + strings.ToLower(base)
+}
+
+// stripPairingWords removes the pairing words, keeping the separator before each. A match takes the separator
+// after the word too, so words next to each other, e.g. "thread_dump_top", need another pass.
+func stripPairingWords(base string) string {
+	for {
+		stripped := pairingWordsRE.ReplaceAllString(base, "${1}")
+		if stripped == base {
+			return base
+		}
+		base = stripped
+	}
+}
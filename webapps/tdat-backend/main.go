@@ -3,11 +3,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
-	"mime/multipart"
 	"net/http"
+	"strconv"
 	"tdat-backend/internal/analyzer"
 	"tdat-backend/internal/parser"
+	"tdat-backend/internal/upload"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,14 +20,18 @@ type AggregatedAnalysisResponse struct {
 	SessionID string                    `json:"session_id"`
 	Timestamp string                    `json:"timestamp"`
 	Threads   []analyzer.AnalyzedThread `json:"threads"`
+	Stacks    []analyzer.SharedStack    `json:"stacks"` // Stack table referenced by the snapshots' stack_id
+	Dumps     []analyzer.DumpReport     `json:"dumps"`
+	Findings  []analyzer.DumpFinding    `json:"findings,omitempty"` // Dump-level findings of the global rules
+	Growth    *analyzer.GrowthReport    `json:"growth,omitempty"`
 	Errors    []string                  `json:"errors,omitempty"`
 }
 
 // Main function: Starts HTTP server
 
 func main() {
-	// Initialize Rules Engine (Grule) by loading rules from rules.grl
-	engine, err := analyzer.NewEngine("./internal/rules/rules.grl")
+	// Initialize Rules Engine (Grule) by loading thread, dump-level and pool rules from the rules directory
+	engine, err := analyzer.NewEngine("./internal/rules")
 	if err != nil {
 		log.Fatalf("Failed to load rules engine: %v", err)
 	}
@@ -36,10 +42,16 @@ func main() {
 		log.Fatalf("Failed to initialize thread enricher: %v", err)
 	}
 
+	// Initialize Activity Classifier by loading the frame signatures of idle, I/O, lock and CPU work.
+	classifier, err := analyzer.NewActivityClassifier("./config/activities.yaml")
+	if err != nil {
+		log.Fatalf("Failed to initialize activity classifier: %v", err)
+	}
+
 	// HTTP Routes
 	http.HandleFunc("/", serveHTML)
 	http.HandleFunc("/parse", func(w http.ResponseWriter, r *http.Request) {
-		parseHandler(w, r, engine, enricher)
+		parseHandler(w, r, engine, enricher, classifier)
 	})
 
 	// Start Server
@@ -51,7 +63,7 @@ func main() {
 
 // Request Handler Logic
 
-func parseHandler(w http.ResponseWriter, r *http.Request, eng *analyzer.RuleEngine, enricher *analyzer.ThreadEnricher) {
+func parseHandler(w http.ResponseWriter, r *http.Request, eng *analyzer.RuleEngine, enricher *analyzer.ThreadEnricher, classifier *analyzer.ActivityClassifier) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -75,25 +87,41 @@ func parseHandler(w http.ResponseWriter, r *http.Request, eng *analyzer.RuleEngi
 	var parsedFiles []analyzer.ParsedFile
 	var errorMessages []string
 
-	// Process each uploaded thread dump file sequentially
-	for i, dumpHeader := range dumpHeaders {
+	// Unpack zip, tar.gz and gz uploads
+	dumpSources, usageSources, cleanup, problems := upload.Collect(dumpHeaders, usageHeaders)
+	defer cleanup()
+	for _, problem := range problems {
+		errorMessages = append(errorMessages, fmt.Sprintf("Upload problem: %v", problem))
+	}
+
+	// Pair each dump with its usage file by explicit mapping, file name stem or embedded timestamp
+	mapping, problems := upload.ParseMapping(r.FormValue("usage_mapping"))
+	pairs, pairingProblems := upload.PairSources(dumpSources, usageSources, mapping)
+	for _, problem := range append(problems, pairingProblems...) {
+		errorMessages = append(errorMessages, fmt.Sprintf("Pairing problem: %v", problem))
+	}
+
+	// Process each thread dump file sequentially
+	for _, pair := range pairs {
 		// Open Thread Dump
-		dumpFile, err := dumpHeader.Open()
+		dumpFile, err := pair.Dump.Open()
 		if err != nil {
-			errorMessages = append(errorMessages, fmt.Sprintf("Failed to open dump %s: %v", dumpHeader.Filename, err))
+			errorMessages = append(errorMessages, fmt.Sprintf("Failed to open dump %s: %v", pair.Dump.Name, err))
 			continue
 		}
 
 		// Open corresponding Usage File if it exists
-		var usageFile multipart.File
-		if i < len(usageHeaders) {
-			if uFile, err := usageHeaders[i].Open(); err == nil {
+		var usageFile io.ReadCloser
+		if pair.Usage != nil {
+			if uFile, err := pair.Usage.Open(); err == nil {
 				usageFile = uFile
+			} else {
+				errorMessages = append(errorMessages, fmt.Sprintf("Failed to open usage file %s for %s: %v", pair.Usage.Name, pair.Dump.Name, err))
 			}
 		}
 
 		// Parse Raw Data & Correlate with Usage
-		threads, err := parser.ProcessAndCorrelate(dumpFile, usageFile)
+		dumps, err := parser.ProcessAndCorrelate(dumpFile, usageFile)
 
 		// Close file handles immediately after reading
 		dumpFile.Close()
@@ -101,38 +129,97 @@ func parseHandler(w http.ResponseWriter, r *http.Request, eng *analyzer.RuleEngi
 			usageFile.Close()
 		}
 
-		if err != nil {
-			errorMessages = append(errorMessages, fmt.Sprintf("Failed to parse %s: %v", dumpHeader.Filename, err))
+		if err != nil && len(dumps) == 0 {
+			errorMessages = append(errorMessages, fmt.Sprintf("Failed to parse %s: %v", pair.Dump.Name, err))
 			continue
 		}
+		if err != nil {
+			// The dump itself was read, only its usage file was not
+			errorMessages = append(errorMessages, fmt.Sprintf("Usage file %s for %s: %v", pair.Usage.Name, pair.Dump.Name, err))
+		}
+
+		// A single file may hold several consecutive snapshots, each is analyzed as its own dump
+		for idx, dump := range dumps {
+			fileName := pair.Dump.Name
+			if len(dumps) > 1 {
+				fileName = snapshotName(pair.Dump.Name, dump, idx)
+			}
+			// Enrichment with Regex Matching - Categorizes threads into pools based on YAML config.
+			enricher.Enrich(dump.Threads)
+
+			// Activity from stack frames - What each thread is doing beyond its Java state.
+			classifier.Classify(dump.Threads)
+
+			// Collect processed data, rules run once all dumps are known
+			parsedFiles = append(parsedFiles, analyzer.ParsedFile{
+				FileName:   fileName,
+				ThreadDump: dump,
+			})
+		}
+	}
+
+	// Order snapshots by capture time, browsers do not keep the selection order of uploads
+	analyzer.SortByCaptureTime(parsedFiles)
+
+	// CPU used between consecutive dumps, the rules judge current load on it
+	analyzer.ComputeCPUDeltas(parsedFiles)
 
-		// Enrichment with Regex Matching - Categorizes threads into pools based on YAML config.
-		enricher.Enrich(threads)
+	// Thread counts of the process and of each pool across dumps, judged by the growth rules and
+	// passed to the thread and dump rules as ThreadCountGrowth
+	growth := analyzer.ComputeGrowth(parsedFiles)
+	if growth != nil {
+		if err := eng.AnalyzeGrowth(growth); err != nil {
+			log.Printf("Growth rule engine error: %v", err)
+			errorMessages = append(errorMessages, fmt.Sprintf("Growth rule analysis failed: %v", err))
+		}
+	}
 
-		// Analysis of Rules Engine
-		// Check if usage data was provided for CPU inference logic
-		usageDataProvided := (usageFile != nil && err == nil)
-		if err := eng.AnalyzeThreads(threads, usageDataProvided); err != nil {
+	// Analysis of Rules Engine
+	var findings []analyzer.DumpFinding
+	for _, file := range parsedFiles {
+		if err := eng.AnalyzeThreads(file.ThreadDump); err != nil {
 			// Log rule engine errors but continue processing other files.
-			log.Printf("Rule engine error on file %s: %v", dumpHeader.Filename, err)
-			errorMessages = append(errorMessages, fmt.Sprintf("Rule analysis failed for %s: %v", dumpHeader.Filename, err))
+			log.Printf("Rule engine error on file %s: %v", file.FileName, err)
+			errorMessages = append(errorMessages, fmt.Sprintf("Rule analysis failed for %s: %v", file.FileName, err))
 		}
 
-		// Collect processed data for later aggregation
-		parsedFiles = append(parsedFiles, analyzer.ParsedFile{
-			FileName: dumpHeader.Filename,
-			Threads:  threads,
-		})
+		// Dump-level rules, once per dump
+		dumpFindings, err := eng.AnalyzeDump(file.ThreadDump, enricher.Settings())
+		if err != nil {
+			log.Printf("Global rule engine error on file %s: %v", file.FileName, err)
+			errorMessages = append(errorMessages, fmt.Sprintf("Global rule analysis failed for %s: %v", file.FileName, err))
+		}
+		for _, finding := range dumpFindings {
+			findings = append(findings, analyzer.DumpFinding{FileName: file.FileName, Finding: finding})
+		}
 	}
 
 	// Aggregation - Pivots data from a file-centric view to a thread-centric history view.
 	aggregatedThreads := analyzer.AggregateThreads(parsedFiles)
 
+	// Multi-snapshot analysis - Threads whose stacks did not move across dumps
+	stuckOpts, problems := stuckOptions(r)
+	for _, problem := range problems {
+		errorMessages = append(errorMessages, fmt.Sprintf("Stuck thread settings: %v", problem))
+	}
+	analyzer.DetectStuckThreads(aggregatedThreads, stuckOpts)
+
+	// Stack Deduplication - Snapshots reference a shared stack table, dumps list clusters of similar stacks
+	stacks := analyzer.DeduplicateStacks(aggregatedThreads)
+	clusterDepth, err := formFrameDepth(r, "cluster_frame_depth", analyzer.DefaultClusterFrameDepth)
+	if err != nil {
+		errorMessages = append(errorMessages, fmt.Sprintf("Stack clustering settings: %v", err))
+	}
+
 	// Construct Final Response Object
 	response := AggregatedAnalysisResponse{
 		SessionID: uuid.New().String(),
 		Timestamp: time.Now().Format(time.RFC3339),
 		Threads:   aggregatedThreads,
+		Findings:  findings,
+		Stacks:    stacks,
+		Dumps:     analyzer.SummarizeDumps(parsedFiles, enricher.Settings(), clusterDepth),
+		Growth:    growth,
 		Errors:    errorMessages,
 	}
 
@@ -145,6 +232,50 @@ func parseHandler(w http.ResponseWriter, r *http.Request, eng *analyzer.RuleEngi
 	}
 }
 
+// snapshotName labels one of several dumps found in the same file by its capture time
+func snapshotName(fileName string, dump *parser.ThreadDump, idx int) string {
+	if !dump.Metadata.CaptureTime.IsZero() {
+		return fmt.Sprintf("%s @ %s", fileName, dump.Metadata.CaptureTime.Format("2006-01-02 15:04:05"))
+	}
+	return fmt.Sprintf("%s #%d", fileName, idx+1)
+}
+
+// stuckOptions reads the frame depth and minimum span of the stuck thread detection, invalid values fall back to the defaults
+func stuckOptions(r *http.Request) (analyzer.StuckOptions, []error) {
+	opts := analyzer.StuckOptions{MinSpan: analyzer.DefaultStuckMinSpan}
+	var problems []error
+	var err error
+	if opts.FrameDepth, err = formFrameDepth(r, "stuck_frame_depth", analyzer.DefaultStuckFrameDepth); err != nil {
+		problems = append(problems, err)
+	}
+	if raw := r.FormValue("stuck_min_span"); raw != "" {
+		// Plain numbers are seconds, "90s" or "3m" are durations
+		span, err := time.ParseDuration(raw)
+		if secs, convErr := strconv.ParseFloat(raw, 64); convErr == nil {
+			span, err = time.Duration(secs*float64(time.Second)), nil
+		}
+		if err != nil || span <= 0 {
+			problems = append(problems, fmt.Errorf("minimum span %q is not a duration, using %s", raw, opts.MinSpan))
+		} else {
+			opts.MinSpan = span
+		}
+	}
+	return opts, problems
+}
+
+// formFrameDepth reads a number of frames from the form, an empty or invalid value gives the default
+func formFrameDepth(r *http.Request, field string, defaultDepth int) (int, error) {
+	raw := r.FormValue(field)
+	if raw == "" {
+		return defaultDepth, nil
+	}
+	depth, err := strconv.Atoi(raw)
+	if err != nil || depth <= 0 {
+		return defaultDepth, fmt.Errorf("frame depth %q is not a positive number, using %d", raw, defaultDepth)
+	}
+	return depth, nil
+}
+
 /* HTML page for testing */
 
 func serveHTML(w http.ResponseWriter, r *http.Request) {
@@ -174,11 +305,27 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
 				<div class="form-group">
 					<label for="thread_dumps">1. Thread Dumps (Required)</label>
 					<input type="file" id="thread_dumps" name="thread_dumps" multiple required>
-					<div class="hint">Upload one or more thread dump files.</div>
+					<div class="hint">Upload one or more thread dump files, or .zip / .tar.gz archives of dumps and usage files.</div>
 				</div>
 				<div class="form-group">
 					<label for="thread_usages">2. Thread Usage (Optional)</label>
 					<input type="file" id="thread_usages" name="thread_usages" multiple>
+					<div class="hint">Paired with dumps by shared name stem (dump_01.txt / usage_01.txt) or timestamp in the name.</div>
+				</div>
+				<div class="form-group">
+					<label for="usage_mapping">3. Usage Mapping (Optional)</label>
+					<input type="text" id="usage_mapping" name="usage_mapping" placeholder="dump_a.txt=top_a.txt, dump_b.txt=top_b.txt">
+				</div>
+				<div class="form-group">
+					<label for="stuck_frame_depth">4. Stuck Thread Detection (Optional)</label>
+					<input type="number" id="stuck_frame_depth" name="stuck_frame_depth" min="1" placeholder="Frames compared (5)">
+					<input type="text" id="stuck_min_span" name="stuck_min_span" placeholder="Minimum span (2m)">
+					<div class="hint">Flags threads whose top frames stay the same in every dump over the minimum span.</div>
+				</div>
+				<div class="form-group">
+					<label for="cluster_frame_depth">5. Stack Clustering (Optional)</label>
+					<input type="number" id="cluster_frame_depth" name="cluster_frame_depth" min="1" placeholder="Frames compared (10)">
+					<div class="hint">Groups threads of a dump whose top frames match, lock addresses aside.</div>
 				</div>
 				<button type="submit">Analyze</button>
 			</form>